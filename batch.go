@@ -0,0 +1,234 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/run/v1"
+)
+
+// DeployResult is the outcome of deploying one service out of many.
+type DeployResult struct {
+	Name    string
+	Service *run.Service
+	Err     error
+}
+
+// BatchDeployServices deploys services with DeployService, running at most
+// maxConcurrent deployments at a time. A failed deployment does not stop the
+// others. Once ctx is cancelled, services that haven't started deploying
+// fail with the context error, while the in-flight ones are waited for.
+// Results are in the same order as services.
+func BatchDeployServices(ctx context.Context, c *run.APIService, region, project string, services []*run.Service, maxConcurrent int) []DeployResult {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	results := make([]DeployResult, len(services))
+	sem := make(chan struct{}, maxConcurrent)
+	var wg sync.WaitGroup
+	for i, svc := range services {
+		if svc.Metadata != nil {
+			results[i].Name = svc.Metadata.Name
+		}
+		if ctx.Err() != nil {
+			results[i].Err = ctx.Err()
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(i int, svc *run.Service) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i].Service, results[i].Err = DeployService(ctx, c, region, project, svc)
+		}(i, svc)
+	}
+	wg.Wait()
+	return results
+}
+
+// MultiRegionDeploy deploys svc to each of the regions with
+// DeployServiceWithOptions, running at most maxParallel deployments at a
+// time. Results are keyed (and named) by region, so the regions that were
+// deployed successfully can be used even if others failed.
+func MultiRegionDeploy(ctx context.Context, project string, regions []string, svc *run.Service, opts DeployOptions, maxParallel int) map[string]DeployResult {
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	var mu sync.Mutex
+	results := make(map[string]DeployResult, len(regions))
+	sem := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	setResult := func(res DeployResult) {
+		mu.Lock()
+		results[res.Name] = res
+		mu.Unlock()
+	}
+	for _, region := range regions {
+		if ctx.Err() != nil {
+			setResult(DeployResult{Name: region, Err: ctx.Err()})
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			setResult(DeployResult{Name: region, Err: ctx.Err()})
+			continue
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(region string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res := DeployResult{Name: region}
+			c, err := client(region)
+			if err != nil {
+				res.Err = fmt.Errorf("failed to initialize client: %w", err)
+			} else {
+				res.Service, res.Err = DeployServiceWithOptions(ctx, c, region, project, svc, opts)
+			}
+			setResult(res)
+		}(region)
+	}
+	wg.Wait()
+	return results
+}
+
+// bulkDeleteConcurrency is how many services BulkDeleteServices deletes at
+// a time.
+const bulkDeleteConcurrency = 10
+
+// BulkDeleteServices deletes the services matching labelSelector, such as
+// "env=preview,pr=123", and returns the names of the deleted ones, sorted.
+// With dryRun, the services that would be deleted are returned instead.
+// Failed deletions don't stop the others and are returned together.
+//
+// The listed services are checked against the selector once more before
+// deleting them, and only equality (k=v), inequality (k!=v) and existence
+// (k) requirements are supported. An empty selector, which would match
+// every service, is rejected.
+func BulkDeleteServices(ctx context.Context, c *run.APIService, region, project, labelSelector string, dryRun bool) ([]string, error) {
+	match, err := parseLabelSelector(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	svcs, err := ListServices(ctx, c, region, project, labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, svc := range svcs {
+		if match(svc.Metadata.Labels) {
+			names = append(names, svc.Metadata.Name)
+		}
+	}
+	if dryRun {
+		return names, nil
+	}
+
+	var mu sync.Mutex
+	var deleted []string
+	var errs multiError
+	sem := make(chan struct{}, bulkDeleteConcurrency)
+	var wg sync.WaitGroup
+	for _, name := range names {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := DeleteService(ctx, c, region, project, name)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			deleted = append(deleted, name)
+		}(name)
+	}
+	wg.Wait()
+	sort.Strings(deleted)
+	if len(errs) > 0 {
+		return deleted, errs
+	}
+	return deleted, nil
+}
+
+// parseLabelSelector returns a function matching labels against a selector
+// made of comma-separated k=v, k==v, k!=v and k requirements.
+func parseLabelSelector(selector string) (func(map[string]string) bool, error) {
+	type requirement struct {
+		key, value string
+		op         string // "=", "!=" or "exists"
+	}
+	var reqs []requirement
+	for _, part := range strings.Split(selector, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var r requirement
+		switch {
+		case strings.Contains(part, "!="):
+			r.key, r.value, _ = strings.Cut(part, "!=")
+			r.op = "!="
+		case strings.Contains(part, "=="):
+			r.key, r.value, _ = strings.Cut(part, "==")
+			r.op = "="
+		case strings.Contains(part, "="):
+			r.key, r.value, _ = strings.Cut(part, "=")
+			r.op = "="
+		default:
+			r.key, r.op = part, "exists"
+		}
+		r.key, r.value = strings.TrimSpace(r.key), strings.TrimSpace(r.value)
+		if !validKey(r.key) || strings.ContainsAny(r.value, " ()!") {
+			return nil, fmt.Errorf("unsupported label selector requirement %q", part)
+		}
+		reqs = append(reqs, r)
+	}
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("label selector must not be empty")
+	}
+	return func(labels map[string]string) bool {
+		for _, r := range reqs {
+			v, ok := labels[r.key]
+			switch r.op {
+			case "=":
+				if !ok || v != r.value {
+					return false
+				}
+			case "!=":
+				if ok && v == r.value {
+					return false
+				}
+			case "exists":
+				if !ok {
+					return false
+				}
+			}
+		}
+		return true
+	}, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestParseLabelSelector(t *testing.T) {
+	labels := map[string]string{"env": "preview", "pr": "123", "team": ""}
+	tests := []struct {
+		selector string
+		match    bool
+		wantErr  bool
+	}{
+		{selector: "env=preview", match: true},
+		{selector: "env==preview", match: true},
+		{selector: "env=prod", match: false},
+		{selector: "env!=prod", match: true},
+		{selector: "env!=preview", match: false},
+		{selector: "owner!=me", match: true},
+		{selector: "team", match: true},
+		{selector: "owner", match: false},
+		{selector: "env=preview,pr=123", match: true},
+		{selector: " env = preview , pr = 123 ", match: true},
+		{selector: "env=preview,pr=124", match: false},
+		{selector: "env=preview,,", match: true},
+		{selector: "", wantErr: true},
+		{selector: " , ", wantErr: true},
+		{selector: "env in (preview)", wantErr: true},
+		{selector: "!env", wantErr: true},
+		{selector: "env=a b", wantErr: true},
+		{selector: "-bad=x", wantErr: true},
+	}
+	for _, tt := range tests {
+		match, err := parseLabelSelector(tt.selector)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseLabelSelector(%q) succeeded, want error", tt.selector)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseLabelSelector(%q) = %v", tt.selector, err)
+			continue
+		}
+		if got := match(labels); got != tt.match {
+			t.Errorf("parseLabelSelector(%q) matches %v = %v, want %v", tt.selector, labels, got, tt.match)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/run/v1"
+)
+
+// ServiceBuilder assembles a *run.Service with a single container, to be
+// passed to DeployService. Problems are reported all at once by Build.
+//
+//	svc, err := NewServiceBuilder().
+//		Name("hello").
+//		Image("gcr.io/google-samples/hello-app:1.0").
+//		Env("FOO", "bar").
+//		MaxInstances(10).
+//		Build()
+type ServiceBuilder struct {
+	name           string
+	image          string
+	env            []*run.EnvVar
+	cpu            string
+	memory         string
+	minInstances   *int
+	maxInstances   *int
+	revisionName   string
+	serviceAccount string
+}
+
+func NewServiceBuilder() *ServiceBuilder {
+	return &ServiceBuilder{}
+}
+
+func (b *ServiceBuilder) Name(name string) *ServiceBuilder {
+	b.name = name
+	return b
+}
+
+func (b *ServiceBuilder) Image(image string) *ServiceBuilder {
+	b.image = image
+	return b
+}
+
+// Env adds a plain-text environment variable to the container.
+func (b *ServiceBuilder) Env(key, val string) *ServiceBuilder {
+	b.env = append(b.env, &run.EnvVar{Name: key, Value: val})
+	return b
+}
+
+// CPU sets the CPU limit. Memory defaults to 512Mi when only CPU is set.
+func (b *ServiceBuilder) CPU(cpu string) *ServiceBuilder {
+	b.cpu = cpu
+	return b
+}
+
+// Memory sets the memory limit. CPU defaults to 1 when only memory is set.
+func (b *ServiceBuilder) Memory(memory string) *ServiceBuilder {
+	b.memory = memory
+	return b
+}
+
+func (b *ServiceBuilder) MinInstances(n int) *ServiceBuilder {
+	b.minInstances = &n
+	return b
+}
+
+func (b *ServiceBuilder) MaxInstances(n int) *ServiceBuilder {
+	b.maxInstances = &n
+	return b
+}
+
+// RevisionName names the revision created by deploying the service. It
+// must be prefixed with the service name, like hello-v1.
+func (b *ServiceBuilder) RevisionName(name string) *ServiceBuilder {
+	b.revisionName = name
+	return b
+}
+
+func (b *ServiceBuilder) ServiceAccount(email string) *ServiceBuilder {
+	b.serviceAccount = email
+	return b
+}
+
+// Build returns the service, or a *ValidationError listing everything
+// that's wrong with it.
+func (b *ServiceBuilder) Build() (*run.Service, error) {
+	var problems []string
+	if b.name == "" {
+		problems = append(problems, "service name is required")
+	}
+	if b.image == "" {
+		problems = append(problems, "container image is required")
+	}
+	if b.revisionName != "" && !strings.HasPrefix(b.revisionName, b.name+"-") {
+		problems = append(problems, fmt.Sprintf("revision name %q must be prefixed with %q", b.revisionName, b.name+"-"))
+	}
+	for _, e := range b.env {
+		if !envVarNameRe.MatchString(e.Name) {
+			problems = append(problems, fmt.Sprintf("invalid environment variable name %q", e.Name))
+		}
+	}
+
+	container := &run.Container{Image: b.image, Env: b.env}
+	svc := &run.Service{
+		ApiVersion: "serving.knative.dev/v1",
+		Kind:       "Service",
+		Metadata:   &run.ObjectMeta{Name: b.name},
+		Spec: &run.ServiceSpec{
+			Template: &run.RevisionTemplate{
+				Metadata: &run.ObjectMeta{Name: b.revisionName},
+				Spec: &run.RevisionSpec{
+					Containers: []*run.Container{container},
+				},
+			},
+		},
+	}
+
+	if b.cpu != "" || b.memory != "" {
+		cpu, memory := b.cpu, b.memory
+		if cpu == "" {
+			cpu = "1"
+		}
+		if memory == "" {
+			memory = "512Mi"
+		}
+		if err := SetResourceLimits(container, cpu, memory); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if b.minInstances != nil || b.maxInstances != nil {
+		lo, hi := 0, maxInstancesLimit
+		if b.minInstances != nil {
+			lo = *b.minInstances
+		}
+		if b.maxInstances != nil {
+			hi = *b.maxInstances
+		}
+		if err := ConfigureMinMaxInstances(svc, lo, hi); err != nil {
+			problems = append(problems, err.Error())
+		}
+		// only keep the bounds that were asked for, Cloud Run picks the
+		// defaults for the others.
+		a := templateAnnotations(svc)
+		if b.minInstances == nil {
+			delete(a, "autoscaling.knative.dev/minScale")
+		}
+		if b.maxInstances == nil {
+			delete(a, "autoscaling.knative.dev/maxScale")
+		}
+	}
+	if b.serviceAccount != "" {
+		if err := SetServiceAccount(svc, b.serviceAccount); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return svc, nil
+}
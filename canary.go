@@ -0,0 +1,97 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/api/run/v1"
+)
+
+const (
+	// canaryDuration is how long a canary is observed before promotion.
+	canaryDuration = time.Minute * 10
+	// canaryCheckInterval is how often the canary error rate is checked.
+	canaryCheckInterval = time.Minute
+)
+
+// CanaryDeploy deploys image as a new revision receiving canaryPercent of
+// the traffic, and watches its error rate for 10 minutes. If the error rate
+// exceeds errorRateThreshold (or can't be determined), all traffic is
+// rolled back to the previous revision and ErrCanaryFailed is returned.
+// Otherwise the new revision is promoted to receive all the traffic.
+func CanaryDeploy(ctx context.Context, c *run.APIService, mc MetricsClient, region, project, name, image string, canaryPercent int64, errorRateThreshold float64) error {
+	if canaryPercent <= 0 || canaryPercent >= 100 {
+		return fmt.Errorf("canary percent must be between 1 and 99, got %d", canaryPercent)
+	}
+	svc, err := getService(c, region, project, name)
+	if err != nil {
+		return fmt.Errorf("failed to get service: %w", err)
+	}
+	stable := svc.Status.LatestReadyRevisionName
+	if stable == "" {
+		return fmt.Errorf("service %q has no ready revision to fall back to", name)
+	}
+	tmpl := revisionTemplate(svc)
+	if len(tmpl.Spec.Containers) == 0 {
+		return fmt.Errorf("service %q has no containers", name)
+	}
+	tmpl.Spec.Containers[0].Image = image
+	tmpl.Metadata.Name = "" // let Cloud Run name the new revision
+	svc.Spec.Traffic = append(trafficTargets(svc.Spec.Traffic, map[string]int64{stable: 100 - canaryPercent}),
+		&run.TrafficTarget{LatestRevision: true, Percent: canaryPercent})
+	if _, err := replaceService(ctx, c, project, svc, DeployOptions{}); err != nil {
+		return err
+	}
+	if err := waitForReady(ctx, c, region, project, name, "Ready", DefaultWaitOptions()); err != nil {
+		return err
+	}
+	if err := waitForReady(ctx, c, region, project, name, "RoutesReady", DefaultWaitOptions()); err != nil {
+		return err
+	}
+	svc, err = getService(c, region, project, name)
+	if err != nil {
+		return fmt.Errorf("failed to get service: %w", err)
+	}
+	canary := svc.Status.LatestReadyRevisionName
+	loggerFrom(ctx).Info("canary is receiving traffic", "service", name, "revision", canary, "percent", canaryPercent)
+
+	start := time.Now()
+	t := time.NewTicker(canaryCheckInterval)
+	defer t.Stop()
+	for time.Since(start) < canaryDuration {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		rate, err := mc.ErrorRate(ctx, project, region, name, canary, time.Since(start))
+		if err == nil && rate <= errorRateThreshold {
+			loggerFrom(ctx).Info("canary error rate", "service", name, "revision", canary, "error_rate", rate)
+			continue
+		}
+		cause := fmt.Errorf("%w: revision %s error rate %.4f exceeds %.4f", ErrCanaryFailed, canary, rate, errorRateThreshold)
+		if err != nil {
+			cause = fmt.Errorf("%w: could not determine error rate of revision %s: %v", ErrCanaryFailed, canary, err)
+		}
+		if rbErr := RollbackToRevision(ctx, c, region, project, name, stable); rbErr != nil {
+			return fmt.Errorf("%w (rollback to %s also failed: %v)", cause, stable, rbErr)
+		}
+		return cause
+	}
+	return SetTrafficByPercent(ctx, c, region, project, name, map[string]int64{canary: 100})
+}
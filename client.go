@@ -0,0 +1,354 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/run/v1"
+)
+
+// Client bundles the Cloud Run API clients needed to manage the services of
+// a project in one region, so that the project and region don't need to be
+// repeated on every call. It logs the operations done through it as
+// structured records, see WithLogger.
+//
+// The methods of Client are the functions of the package that read or
+// change services, revisions, traffic and IAM policies through the API,
+// and that callers would want a record of. Each one logs a record once it
+// returns, and the progress of long-running ones, such as traffic
+// migrations, is logged as it happens. Functions that only edit a
+// *run.Service in memory, such as the Set* and Configure* ones, make no
+// API calls and are not wrapped; neither are the reporting helpers such as
+// GetAuditLog or GetCloudRunQuotas, which use other APIs.
+type Client struct {
+	Project string
+	Region  string
+
+	// APIService talks to the regional endpoint, used for everything but
+	// IAM policies.
+	APIService *run.APIService
+	// IAMService talks to the global endpoint, used for IAM policies.
+	IAMService *run.APIService
+
+	logger *slog.Logger
+}
+
+// NewClient creates the regional and global API clients with opts, which
+// must not override the endpoint.
+func NewClient(ctx context.Context, project, region string, opts ...option.ClientOption) (*Client, error) {
+	regional := append([]option.ClientOption{
+		option.WithEndpoint(fmt.Sprintf("https://%s-run.googleapis.com", region)),
+	}, opts...)
+	api, err := run.NewService(ctx, regional...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize regional client: %w", err)
+	}
+	iam, err := run.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize global client: %w", err)
+	}
+	return &Client{
+		Project:    project,
+		Region:     region,
+		APIService: api,
+		IAMService: iam,
+		logger:     discardLogger,
+	}, nil
+}
+
+// WithLogger returns a copy of the client that writes its records to l.
+// Nothing is logged by default.
+func (c *Client) WithLogger(l *slog.Logger) *Client {
+	out := *c
+	out.logger = l
+	if l == nil {
+		out.logger = discardLogger
+	}
+	return &out
+}
+
+// do runs op with the logger of the client in its context, then logs how it
+// went along with attrs.
+func (c *Client) do(ctx context.Context, msg string, attrs []slog.Attr, op func(ctx context.Context) error) error {
+	start := time.Now()
+	err := op(contextWithLogger(ctx, c.logger))
+	attrs = append(attrs,
+		slog.String("region", c.Region),
+		slog.String("project", c.Project),
+		slog.Duration("duration", time.Since(start)))
+	if err != nil {
+		c.logger.LogAttrs(ctx, slog.LevelError, msg, append(attrs, slog.String("status", "failed"), slog.Any("error", err))...)
+		return err
+	}
+	c.logger.LogAttrs(ctx, slog.LevelInfo, msg, append(attrs, slog.String("status", "succeeded"))...)
+	return nil
+}
+
+// GetService returns the service with the given name.
+func (c *Client) GetService(ctx context.Context, name string) (*run.Service, error) {
+	var out *run.Service
+	err := c.do(ctx, "get service", []slog.Attr{slog.String("service", name)}, func(ctx context.Context) (err error) {
+		out, err = getService(c.APIService, c.Region, c.Project, name)
+		return err
+	})
+	return out, err
+}
+
+// DeployService deploys svc like the DeployService function.
+func (c *Client) DeployService(ctx context.Context, svc *run.Service) (*run.Service, error) {
+	return c.DeployServiceWithOptions(ctx, svc, DeployOptions{})
+}
+
+// DeployServiceWithOptions deploys svc like the DeployServiceWithOptions
+// function.
+func (c *Client) DeployServiceWithOptions(ctx context.Context, svc *run.Service, opts DeployOptions) (*run.Service, error) {
+	var out *run.Service
+	err := c.do(ctx, "deploy service", serviceAttrs(svc), func(ctx context.Context) (err error) {
+		out, err = DeployServiceWithOptions(ctx, c.APIService, c.Region, c.Project, svc, opts)
+		return err
+	})
+	return out, err
+}
+
+// CreateOrUpdateService deploys svc like the CreateOrUpdateService
+// function.
+func (c *Client) CreateOrUpdateService(ctx context.Context, svc *run.Service) (*run.Service, OpKind, error) {
+	var out *run.Service
+	var kind OpKind
+	err := c.do(ctx, "create or update service", serviceAttrs(svc), func(ctx context.Context) (err error) {
+		out, kind, err = CreateOrUpdateService(ctx, c.APIService, c.Region, c.Project, svc)
+		return err
+	})
+	return out, kind, err
+}
+
+// EnsureServiceExists creates svc unless it exists, like the
+// EnsureServiceExists function.
+func (c *Client) EnsureServiceExists(ctx context.Context, svc *run.Service) (*run.Service, bool, error) {
+	var out *run.Service
+	var created bool
+	err := c.do(ctx, "ensure service exists", serviceAttrs(svc), func(ctx context.Context) (err error) {
+		out, created, err = EnsureServiceExists(ctx, c.APIService, c.Region, c.Project, svc)
+		return err
+	})
+	return out, created, err
+}
+
+// SyncServiceFromConfig deploys the service in configFile unless it's in
+// sync, like the SyncServiceFromConfig function.
+func (c *Client) SyncServiceFromConfig(ctx context.Context, configFile string) (*run.Service, error) {
+	var out *run.Service
+	err := c.do(ctx, "sync service", []slog.Attr{slog.String("config", configFile)}, func(ctx context.Context) (err error) {
+		out, err = SyncServiceFromConfig(ctx, c.APIService, c.Region, c.Project, configFile)
+		return err
+	})
+	return out, err
+}
+
+// DeleteService deletes the service like the DeleteService function.
+func (c *Client) DeleteService(ctx context.Context, name string) error {
+	return c.do(ctx, "delete service", []slog.Attr{slog.String("service", name)}, func(ctx context.Context) error {
+		return DeleteService(ctx, c.APIService, c.Region, c.Project, name)
+	})
+}
+
+// BulkDeleteServices deletes the services matching labelSelector like the
+// BulkDeleteServices function.
+func (c *Client) BulkDeleteServices(ctx context.Context, labelSelector string, dryRun bool) ([]string, error) {
+	var out []string
+	attrs := []slog.Attr{slog.String("selector", labelSelector), slog.Bool("dry_run", dryRun)}
+	err := c.do(ctx, "bulk delete services", attrs, func(ctx context.Context) (err error) {
+		out, err = BulkDeleteServices(ctx, c.APIService, c.Region, c.Project, labelSelector, dryRun)
+		return err
+	})
+	return out, err
+}
+
+// ListServices returns the services matching labelSelector, or all of them
+// if it's empty.
+func (c *Client) ListServices(ctx context.Context, labelSelector string) ([]*run.Service, error) {
+	var out []*run.Service
+	err := c.do(ctx, "list services", []slog.Attr{slog.String("selector", labelSelector)}, func(ctx context.Context) (err error) {
+		out, err = ListServices(ctx, c.APIService, c.Region, c.Project, labelSelector)
+		return err
+	})
+	return out, err
+}
+
+// WaitForReady waits until the condition of the service is True, and fails
+// as soon as it's False.
+func (c *Client) WaitForReady(ctx context.Context, name, condition string, opts WaitOptions) error {
+	attrs := []slog.Attr{slog.String("service", name), slog.String("condition", condition)}
+	return c.do(ctx, "wait for service", attrs, func(ctx context.Context) error {
+		return waitForReady(ctx, c.APIService, c.Region, c.Project, name, condition, opts)
+	})
+}
+
+// ListRevisions returns the revisions of the service like the ListRevisions
+// function.
+func (c *Client) ListRevisions(ctx context.Context, name string) ([]*run.Revision, error) {
+	var out []*run.Revision
+	err := c.do(ctx, "list revisions", []slog.Attr{slog.String("service", name)}, func(ctx context.Context) (err error) {
+		out, err = ListRevisions(ctx, c.APIService, c.Region, c.Project, name)
+		return err
+	})
+	return out, err
+}
+
+// DeleteOldRevisions deletes revisions of the service like the
+// DeleteOldRevisions function, and returns how many were deleted.
+func (c *Client) DeleteOldRevisions(ctx context.Context, name string, keep int) (int, error) {
+	var n int
+	err := c.do(ctx, "delete old revisions", []slog.Attr{slog.String("service", name)}, func(ctx context.Context) (err error) {
+		n, err = DeleteOldRevisions(ctx, c.APIService, c.Region, c.Project, name, keep)
+		return err
+	})
+	return n, err
+}
+
+// RollbackToRevision sends all the traffic of the service to revision like
+// the RollbackToRevision function.
+func (c *Client) RollbackToRevision(ctx context.Context, name, revision string) error {
+	attrs := []slog.Attr{slog.String("service", name), slog.String("revision", revision)}
+	return c.do(ctx, "rollback", attrs, func(ctx context.Context) error {
+		return RollbackToRevision(ctx, c.APIService, c.Region, c.Project, name, revision)
+	})
+}
+
+// SetTrafficByPercent splits the traffic of the service like the
+// SetTrafficByPercent function.
+func (c *Client) SetTrafficByPercent(ctx context.Context, name string, split map[string]int64) error {
+	attrs := []slog.Attr{slog.String("service", name), slog.Any("traffic", split)}
+	return c.do(ctx, "set traffic", attrs, func(ctx context.Context) error {
+		return SetTrafficByPercent(ctx, c.APIService, c.Region, c.Project, name, split)
+	})
+}
+
+// GradualTrafficMigration moves the traffic of the service from oldRev to
+// newRev like the GradualTrafficMigration function, logging each step.
+func (c *Client) GradualTrafficMigration(ctx context.Context, name, oldRev, newRev string, stepPercent int64, stepInterval time.Duration) error {
+	attrs := []slog.Attr{slog.String("service", name), slog.String("from", oldRev), slog.String("revision", newRev)}
+	return c.do(ctx, "migrate traffic", attrs, func(ctx context.Context) error {
+		return GradualTrafficMigration(ctx, c.APIService, c.Region, c.Project, name, oldRev, newRev, stepPercent, stepInterval)
+	})
+}
+
+// PinToCurrentRevision sends all the traffic of the service to its latest
+// ready revision like the PinToCurrentRevision function.
+func (c *Client) PinToCurrentRevision(ctx context.Context, name string) (string, error) {
+	var rev string
+	err := c.do(ctx, "pin traffic", []slog.Attr{slog.String("service", name)}, func(ctx context.Context) (err error) {
+		rev, err = PinToCurrentRevision(ctx, c.APIService, c.Region, c.Project, name)
+		return err
+	})
+	return rev, err
+}
+
+// CopyTrafficConfig gives dstName the traffic split of srcName like the
+// CopyTrafficConfig function.
+func (c *Client) CopyTrafficConfig(ctx context.Context, srcName, dstName string, revisionMapping map[string]string) error {
+	attrs := []slog.Attr{slog.String("service", dstName), slog.String("from", srcName)}
+	return c.do(ctx, "copy traffic", attrs, func(ctx context.Context) error {
+		return CopyTrafficConfig(ctx, c.APIService, c.Region, c.Project, srcName, dstName, revisionMapping)
+	})
+}
+
+// TagRevision gives a revision of the service its own URL like the
+// TagRevision function.
+func (c *Client) TagRevision(ctx context.Context, name, revision, tag string) error {
+	attrs := []slog.Attr{slog.String("service", name), slog.String("revision", revision), slog.String("tag", tag)}
+	return c.do(ctx, "tag revision", attrs, func(ctx context.Context) error {
+		return TagRevision(ctx, c.APIService, c.Region, c.Project, name, revision, tag)
+	})
+}
+
+// UntagRevision removes tag from the service like the UntagRevision
+// function.
+func (c *Client) UntagRevision(ctx context.Context, name, tag string) error {
+	attrs := []slog.Attr{slog.String("service", name), slog.String("tag", tag)}
+	return c.do(ctx, "untag revision", attrs, func(ctx context.Context) error {
+		return UntagRevision(ctx, c.APIService, c.Region, c.Project, name, tag)
+	})
+}
+
+// CanaryDeploy deploys image as a canary of the service like the
+// CanaryDeploy function, logging its error rate as it's watched.
+func (c *Client) CanaryDeploy(ctx context.Context, mc MetricsClient, name, image string, canaryPercent int64, errorRateThreshold float64) error {
+	attrs := []slog.Attr{slog.String("service", name), slog.String("image", image)}
+	return c.do(ctx, "canary deploy", attrs, func(ctx context.Context) error {
+		return CanaryDeploy(ctx, c.APIService, mc, c.Region, c.Project, name, image, canaryPercent, errorRateThreshold)
+	})
+}
+
+// GetIAMPolicy returns the IAM policy of the service.
+func (c *Client) GetIAMPolicy(ctx context.Context, name string) (*run.Policy, error) {
+	var out *run.Policy
+	err := c.do(ctx, "get iam policy", []slog.Attr{slog.String("service", name)}, func(ctx context.Context) (err error) {
+		out, err = GetIAMPolicy(ctx, c.IAMService, c.Project, c.Region, name)
+		return err
+	})
+	return out, err
+}
+
+// AddIAMBinding grants role on the service to member like the AddIAMBinding
+// function.
+func (c *Client) AddIAMBinding(ctx context.Context, name, member, role string) error {
+	attrs := []slog.Attr{slog.String("service", name), slog.String("member", member), slog.String("role", role)}
+	return c.do(ctx, "add iam binding", attrs, func(ctx context.Context) error {
+		return AddIAMBinding(ctx, c.IAMService, c.Project, c.Region, name, member, role)
+	})
+}
+
+// RemoveIAMBinding revokes role on the service from member like the
+// RemoveIAMBinding function.
+func (c *Client) RemoveIAMBinding(ctx context.Context, name, member, role string) error {
+	attrs := []slog.Attr{slog.String("service", name), slog.String("member", member), slog.String("role", role)}
+	return c.do(ctx, "remove iam binding", attrs, func(ctx context.Context) error {
+		return RemoveIAMBinding(ctx, c.IAMService, c.Project, c.Region, name, member, role)
+	})
+}
+
+// MakePublic lets anyone invoke the service like the MakePublic function.
+func (c *Client) MakePublic(ctx context.Context, name string) error {
+	return c.do(ctx, "make service public", []slog.Attr{slog.String("service", name)}, func(ctx context.Context) error {
+		return MakePublic(ctx, c.APIService, c.IAMService, c.Project, c.Region, name)
+	})
+}
+
+// MakeServicePrivate stops unauthenticated invocations of the service like
+// the MakeServicePrivate function.
+func (c *Client) MakeServicePrivate(ctx context.Context, name string) error {
+	return c.do(ctx, "make service private", []slog.Attr{slog.String("service", name)}, func(ctx context.Context) error {
+		return MakeServicePrivate(ctx, c.IAMService, c.Project, c.Region, name)
+	})
+}
+
+// serviceAttrs returns the log attributes identifying svc and the revision
+// it's deployed as, if named.
+func serviceAttrs(svc *run.Service) []slog.Attr {
+	var attrs []slog.Attr
+	if svc.Metadata != nil {
+		attrs = append(attrs, slog.String("service", svc.Metadata.Name))
+	}
+	if svc.Spec != nil && svc.Spec.Template != nil && svc.Spec.Template.Metadata != nil && svc.Spec.Template.Metadata.Name != "" {
+		attrs = append(attrs, slog.String("revision", svc.Spec.Template.Metadata.Name))
+	}
+	return attrs
+}
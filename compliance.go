@@ -0,0 +1,170 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/run/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Severity tells how bad a ComplianceViolation is.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// CompliancePolicy is a set of standards services must meet, checked by
+// CheckCompliance. Rules left at their zero value are not checked. It's
+// usually loaded from YAML with LoadCompliancePolicy:
+//
+//	requiredLabels: [team, env]
+//	minInstances: 1
+//	allowedIngress: [internal, internal-and-cloud-load-balancing]
+//	requireServiceAccount: true
+//	allowedImageRegistries: [us-docker.pkg.dev/my-project/]
+//	denyPublicAccess: true
+//	severities:
+//	  min-instances: warning
+type CompliancePolicy struct {
+	// RequiredLabels must be set on the service (rule "required-labels").
+	RequiredLabels []string `json:"requiredLabels,omitempty"`
+	// MinInstances is the lowest minimum instances allowed (rule
+	// "min-instances").
+	MinInstances int `json:"minInstances,omitempty"`
+	// AllowedIngress are the allowed ingress settings (rule "ingress").
+	AllowedIngress []string `json:"allowedIngress,omitempty"`
+	// RequireServiceAccount forbids running as the default compute service
+	// account (rule "service-account").
+	RequireServiceAccount bool `json:"requireServiceAccount,omitempty"`
+	// AllowedImageRegistries are the prefixes container images must start
+	// with (rule "image-registry").
+	AllowedImageRegistries []string `json:"allowedImageRegistries,omitempty"`
+	// DenyPublicAccess forbids disabling the invoker IAM check (rule
+	// "public-access"). allUsers bindings are part of the IAM policy
+	// rather than the service, and are not checked.
+	DenyPublicAccess bool `json:"denyPublicAccess,omitempty"`
+	// Severities overrides the severity of violations of the rules, which
+	// default to SeverityError.
+	Severities map[string]Severity `json:"severities,omitempty"`
+}
+
+// ComplianceViolation is a way in which a service does not meet a
+// CompliancePolicy.
+type ComplianceViolation struct {
+	Rule     string
+	Severity Severity
+	Message  string
+}
+
+// LoadCompliancePolicy reads a CompliancePolicy from a YAML or JSON file.
+func LoadCompliancePolicy(path string) (CompliancePolicy, error) {
+	var p CompliancePolicy
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return p, fmt.Errorf("failed to read compliance policy: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(b, &p); err != nil {
+		return p, fmt.Errorf("failed to parse compliance policy %s: %w", path, err)
+	}
+	for rule, s := range p.Severities {
+		if s != SeverityError && s != SeverityWarning {
+			return p, fmt.Errorf("invalid severity %q for rule %q (valid values: %s, %s)", s, rule, SeverityError, SeverityWarning)
+		}
+	}
+	return p, nil
+}
+
+// CheckCompliance returns the ways in which svc does not meet policy,
+// ordered by rule, or nil if it meets it.
+func CheckCompliance(svc *run.Service, policy CompliancePolicy) []ComplianceViolation {
+	var out []ComplianceViolation
+	add := func(rule, format string, args ...interface{}) {
+		sev, ok := policy.Severities[rule]
+		if !ok {
+			sev = SeverityError
+		}
+		out = append(out, ComplianceViolation{Rule: rule, Severity: sev, Message: fmt.Sprintf(format, args...)})
+	}
+
+	var meta, tmplMeta run.ObjectMeta
+	var spec run.RevisionSpec
+	if svc.Metadata != nil {
+		meta = *svc.Metadata
+	}
+	if svc.Spec != nil && svc.Spec.Template != nil {
+		if svc.Spec.Template.Metadata != nil {
+			tmplMeta = *svc.Spec.Template.Metadata
+		}
+		if svc.Spec.Template.Spec != nil {
+			spec = *svc.Spec.Template.Spec
+		}
+	}
+
+	for _, l := range policy.RequiredLabels {
+		if _, ok := meta.Labels[l]; !ok {
+			add("required-labels", "label %q is not set", l)
+		}
+	}
+	if policy.DenyPublicAccess && meta.Annotations["run.googleapis.com/invoker-iam-disabled"] == "true" {
+		add("public-access", "invoker iam check is disabled, anyone can invoke the service")
+	}
+	if len(policy.AllowedIngress) > 0 {
+		ingress := meta.Annotations["run.googleapis.com/ingress"]
+		if ingress == "" {
+			ingress = "all"
+		}
+		allowed := false
+		for _, v := range policy.AllowedIngress {
+			if v == ingress {
+				allowed = true
+			}
+		}
+		if !allowed {
+			add("ingress", "ingress %q is not allowed (allowed: %s)", ingress, strings.Join(policy.AllowedIngress, ", "))
+		}
+	}
+	if policy.MinInstances > 0 {
+		n, _ := strconv.Atoi(tmplMeta.Annotations["autoscaling.knative.dev/minScale"])
+		if n < policy.MinInstances {
+			add("min-instances", "min instances is %d, must be at least %d", n, policy.MinInstances)
+		}
+	}
+	if policy.RequireServiceAccount && (spec.ServiceAccountName == "" || computeServiceAccountRe.MatchString(spec.ServiceAccountName)) {
+		add("service-account", "service runs as the default compute service account")
+	}
+	if len(policy.AllowedImageRegistries) > 0 {
+		for _, c := range spec.Containers {
+			allowed := false
+			for _, prefix := range policy.AllowedImageRegistries {
+				if strings.HasPrefix(c.Image, prefix) {
+					allowed = true
+				}
+			}
+			if !allowed {
+				add("image-registry", "image %q is not from an allowed registry", c.Image)
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Rule < out[j].Rule })
+	return out
+}
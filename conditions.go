@@ -0,0 +1,115 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/api/run/v1"
+)
+
+// Condition is a status condition of a service, such as Ready, with its
+// timestamp parsed.
+type Condition struct {
+	Type               string
+	Status             string
+	Reason             string
+	Message            string
+	LastTransitionTime time.Time
+}
+
+// ServiceConditionSummary returns the status conditions of svc in the
+// order reported by the API, or nil if it has no status yet.
+func ServiceConditionSummary(svc *run.Service) []Condition {
+	if svc.Status == nil {
+		return nil
+	}
+	out := make([]Condition, 0, len(svc.Status.Conditions))
+	for _, c := range svc.Status.Conditions {
+		t, _ := time.Parse(time.RFC3339Nano, c.LastTransitionTime)
+		out = append(out, Condition{
+			Type:               c.Type,
+			Status:             c.Status,
+			Reason:             c.Reason,
+			Message:            c.Message,
+			LastTransitionTime: t,
+		})
+	}
+	return out
+}
+
+// healthConditions are the conditions that must all be True for a service
+// to be serving its latest configuration.
+var healthConditions = []string{"Ready", "ConfigurationsReady", "RoutesReady"}
+
+// IsServiceHealthy reports whether the Ready, ConfigurationsReady and
+// RoutesReady conditions of svc are all True.
+func IsServiceHealthy(svc *run.Service) bool {
+	return ServiceHealthError(svc) == nil
+}
+
+// ServiceHealthError describes which of the conditions checked by
+// IsServiceHealthy are not True, or returns nil if the service is healthy.
+func ServiceHealthError(svc *run.Service) error {
+	conds := make(map[string]Condition)
+	for _, c := range ServiceConditionSummary(svc) {
+		conds[c.Type] = c
+	}
+	var errs multiError
+	for _, typ := range healthConditions {
+		c, ok := conds[typ]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("condition %q is not reported", typ))
+		case c.Status != "True":
+			errs = append(errs, fmt.Errorf("condition %q is %s (reason:%s) %s", typ, c.Status, c.Reason, c.Message))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// GetServiceCreationTime returns when the service was created.
+func GetServiceCreationTime(svc *run.Service) (time.Time, error) {
+	if svc.Metadata == nil || svc.Metadata.CreationTimestamp == "" {
+		return time.Time{}, fmt.Errorf("%w: metadata.creationTimestamp", ErrFieldMissing)
+	}
+	t, err := time.Parse(time.RFC3339, svc.Metadata.CreationTimestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid creation timestamp: %w", err)
+	}
+	return t, nil
+}
+
+// GetServiceLastModifiedTime returns approximately when the service was
+// last modified. The API does not record it (the
+// serving.knative.dev/lastModifier annotation only names who did it), so
+// the time of the latest status condition transition is used instead, as
+// every modification makes the conditions transition while it rolls out.
+func GetServiceLastModifiedTime(svc *run.Service) (time.Time, error) {
+	var last time.Time
+	for _, c := range ServiceConditionSummary(svc) {
+		if c.LastTransitionTime.After(last) {
+			last = c.LastTransitionTime
+		}
+	}
+	if last.IsZero() {
+		return time.Time{}, fmt.Errorf("%w: status.conditions[].lastTransitionTime", ErrFieldMissing)
+	}
+	return last, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"google.golang.org/api/run/v1"
+)
+
+var (
+	validCPUs = map[string]bool{"1": true, "2": true, "4": true, "6": true, "8": true}
+	memoryRe  = regexp.MustCompile(`^([0-9]+)(Mi|Gi)$`)
+)
+
+// memoryMiB parses a memory quantity such as "512Mi" or "2Gi" and returns
+// it in MiB, or -1 if it's not in one of those forms.
+func memoryMiB(memory string) int {
+	m := memoryRe.FindStringSubmatch(memory)
+	if m == nil {
+		return -1
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return -1
+	}
+	if m[2] == "Gi" {
+		n *= 1024
+	}
+	return n
+}
+
+// SetResourceLimits sets the CPU ("1", "2", "4", "6" or "8") and memory
+// ("128Mi" to "32Gi") limits of the container. Requests are set to the same
+// values, as recommended for Cloud Run.
+func SetResourceLimits(container *run.Container, cpu string, memory string) error {
+	if !validCPUs[cpu] {
+		return fmt.Errorf("invalid cpu limit %q (valid values: 1, 2, 4, 6, 8)", cpu)
+	}
+	if mib := memoryMiB(memory); mib < 128 || mib > 32*1024 {
+		return fmt.Errorf("invalid memory limit %q, must be between 128Mi and 32Gi (like 512Mi or 2Gi)", memory)
+	}
+	if container.Resources == nil {
+		container.Resources = &run.ResourceRequirements{}
+	}
+	r := container.Resources
+	if r.Limits == nil {
+		r.Limits = make(map[string]string)
+	}
+	if r.Requests == nil {
+		r.Requests = make(map[string]string)
+	}
+	r.Limits["cpu"], r.Limits["memory"] = cpu, memory
+	r.Requests["cpu"], r.Requests["memory"] = cpu, memory
+	return nil
+}
+
+// validateProbe checks that probe has exactly one HTTP, gRPC or exec
+// handler and sane timings.
+func validateProbe(probe *run.Probe) error {
+	if probe == nil {
+		return fmt.Errorf("probe is not set")
+	}
+	if probe.TcpSocket != nil {
+		return fmt.Errorf("tcp probes are not supported, use an http, grpc or exec probe")
+	}
+	handlers := 0
+	for _, set := range []bool{probe.HttpGet != nil, probe.Grpc != nil, probe.Exec != nil} {
+		if set {
+			handlers++
+		}
+	}
+	if handlers != 1 {
+		return fmt.Errorf("probe must have exactly one of httpGet, grpc or exec, got %d", handlers)
+	}
+	if probe.PeriodSeconds <= 0 {
+		return fmt.Errorf("probe periodSeconds must be positive, got %d", probe.PeriodSeconds)
+	}
+	if probe.FailureThreshold < 0 {
+		return fmt.Errorf("probe failureThreshold must not be negative, got %d", probe.FailureThreshold)
+	}
+	return nil
+}
+
+// ConfigureLivenessProbe sets the probe that restarts the container when it
+// fails.
+func ConfigureLivenessProbe(container *run.Container, probe *run.Probe) error {
+	if err := validateProbe(probe); err != nil {
+		return fmt.Errorf("invalid liveness probe: %w", err)
+	}
+	container.LivenessProbe = probe
+	return nil
+}
+
+// ConfigureStartupProbe sets the probe that must succeed before the
+// container receives traffic.
+func ConfigureStartupProbe(container *run.Container, probe *run.Probe) error {
+	if err := validateProbe(probe); err != nil {
+		return fmt.Errorf("invalid startup probe: %w", err)
+	}
+	container.StartupProbe = probe
+	return nil
+}
+
+// SetContainerPort declares the port the container listens on for
+// requests, with "http1" or "h2c" (for end-to-end HTTP/2) as name, or no
+// name for the default. Cloud Run allows a single port, so
+// ErrMultiplePorts is returned if the container already declares another.
+func SetContainerPort(container *run.Container, port int64, name string) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %d, must be between 1 and 65535", port)
+	}
+	if name != "" && name != "http1" && name != "h2c" {
+		return fmt.Errorf("invalid port name %q (valid values: http1, h2c, or empty)", name)
+	}
+	for _, p := range container.Ports {
+		if p.ContainerPort != port {
+			return fmt.Errorf("%w: already declares port %d", ErrMultiplePorts, p.ContainerPort)
+		}
+	}
+	container.Ports = []*run.ContainerPort{{ContainerPort: port, Name: name}}
+	return nil
+}
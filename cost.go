@@ -0,0 +1,104 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/run/v1"
+)
+
+// PricingTable holds the prices, in USD, that Cloud Run charges for
+// request-based billing.
+type PricingTable struct {
+	PerVCPUSecond      float64
+	PerGiBSecond       float64
+	PerMillionRequests float64
+}
+
+// DefaultPricingTable returns the published tier 1 prices of Cloud Run,
+// see https://cloud.google.com/run/pricing.
+func DefaultPricingTable() PricingTable {
+	return PricingTable{
+		PerVCPUSecond:      0.000024,
+		PerGiBSecond:       0.0000025,
+		PerMillionRequests: 0.40,
+	}
+}
+
+// CostEstimate is a monthly cost in USD broken down by what's billed.
+type CostEstimate struct {
+	CPUCost     float64
+	MemoryCost  float64
+	RequestCost float64
+}
+
+// Total returns the sum of the costs.
+func (e CostEstimate) Total() float64 {
+	return e.CPUCost + e.MemoryCost + e.RequestCost
+}
+
+// EstimateRevisionCost approximates the monthly cost of a revision serving
+// monthlyRequests requests that take avgLatencyMs each.
+//
+// Instances are assumed to be busy with as many concurrent requests as
+// the revision allows, so this is a lower bound: it leaves out the free
+// tier, idle minimum instances and the rounding of billable time.
+func EstimateRevisionCost(spec *run.RevisionSpec, monthlyRequests int64, avgLatencyMs int64, pricing PricingTable) CostEstimate {
+	var vcpu, gib float64
+	for _, c := range spec.Containers {
+		cpu, mem := "1", "512Mi"
+		if c.Resources != nil {
+			if v, ok := c.Resources.Limits["cpu"]; ok {
+				cpu = v
+			}
+			if v, ok := c.Resources.Limits["memory"]; ok {
+				mem = v
+			}
+		}
+		vcpu += cpuCores(cpu)
+		if mib := memoryMiB(mem); mib > 0 {
+			gib += float64(mib) / 1024
+		}
+	}
+	concurrency := spec.ContainerConcurrency
+	if concurrency <= 0 {
+		concurrency = 80
+	}
+	instanceSeconds := float64(monthlyRequests) * float64(avgLatencyMs) / 1000 / float64(concurrency)
+	return CostEstimate{
+		CPUCost:     instanceSeconds * vcpu * pricing.PerVCPUSecond,
+		MemoryCost:  instanceSeconds * gib * pricing.PerGiBSecond,
+		RequestCost: float64(monthlyRequests) / 1e6 * pricing.PerMillionRequests,
+	}
+}
+
+// cpuCores parses a CPU quantity such as "2" or "500m", returning 0 if
+// it's neither.
+func cpuCores(cpu string) float64 {
+	if m := strings.TrimSuffix(cpu, "m"); m != cpu {
+		n, err := strconv.ParseFloat(m, 64)
+		if err != nil {
+			return 0
+		}
+		return n / 1000
+	}
+	n, err := strconv.ParseFloat(cpu, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
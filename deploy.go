@@ -0,0 +1,318 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/run/v1"
+)
+
+// DeployOptions customizes DeployServiceWithOptions.
+type DeployOptions struct {
+	// DryRun has the API validate the request without persisting anything.
+	// Validation failures are returned as a *ValidationError.
+	DryRun bool
+
+	// SecureByDefault makes newly created services reachable only from
+	// within the project's VPC networks and only by principals granted
+	// roles/run.invoker, overriding anything in the service that would
+	// make it public, with a warning.
+	// New services have no allUsers binding unless it's granted with
+	// AddIAMBinding or MakePublic afterwards. Existing services are not
+	// changed.
+	SecureByDefault bool
+}
+
+// DeployService creates svc if it does not exist yet, and otherwise
+// replaces the existing service with it. It returns the service object
+// returned by the API, which might not be ready yet.
+//
+// Updates carry the resourceVersion of svc, or of a fresh copy of the
+// service if svc has none, so that the API rejects a coinciding update
+// instead of overwriting it. In that case ErrConcurrentModification is
+// returned.
+func DeployService(ctx context.Context, c *run.APIService, region, project string, svc *run.Service) (*run.Service, error) {
+	return DeployServiceWithOptions(ctx, c, region, project, svc, DeployOptions{})
+}
+
+// DeployServiceWithOptions is DeployService with the given options.
+func DeployServiceWithOptions(ctx context.Context, c *run.APIService, region, project string, svc *run.Service, opts DeployOptions) (*run.Service, error) {
+	if svc.Metadata == nil || svc.Metadata.Name == "" {
+		return nil, fmt.Errorf("service name is not set")
+	}
+	name := svc.Metadata.Name
+	exists, err := serviceExists(c, region, project, name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if opts.SecureByDefault {
+			if svc, err = secureService(ctx, svc); err != nil {
+				return nil, err
+			}
+		}
+		call := c.Namespaces.Services.Create("namespaces/"+project, svc).Context(ctx)
+		if opts.DryRun {
+			call.DryRun("all")
+		}
+		out, err := call.Do()
+		if err != nil {
+			if opts.DryRun {
+				if verr := dryRunError(err); verr != nil {
+					return nil, verr
+				}
+			}
+			return nil, fmt.Errorf("failed to create service: %w", apiError(err))
+		}
+		return out, nil
+	}
+
+	cur, err := getService(c, region, project, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current service: %w", err)
+	}
+	// work on a copy so the caller's object is not modified.
+	desired := *svc
+	meta := *svc.Metadata
+	if meta.ResourceVersion == "" {
+		meta.ResourceVersion = cur.Metadata.ResourceVersion
+	}
+	desired.Metadata = &meta
+	return replaceService(ctx, c, project, &desired, opts)
+}
+
+// secureService returns a copy of svc with internal ingress and the
+// invoker IAM check enabled, warning about settings that are overridden.
+func secureService(ctx context.Context, svc *run.Service) (*run.Service, error) {
+	out, err := copyService(svc)
+	if err != nil {
+		return nil, err
+	}
+	a := out.Metadata.Annotations
+	if v, ok := a["run.googleapis.com/ingress"]; ok && v != "internal" {
+		loggerFrom(ctx).Warn("overriding ingress of new service to internal",
+			"service", out.Metadata.Name, "ingress", v)
+	}
+	if a["run.googleapis.com/invoker-iam-disabled"] == "true" {
+		loggerFrom(ctx).Warn("not disabling invoker iam check of new service, it would allow allUsers",
+			"service", out.Metadata.Name)
+		delete(a, "run.googleapis.com/invoker-iam-disabled")
+	}
+	if err := SetIngressMode(out, "internal"); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// replaceService writes svc back to the API, translating a failed
+// resourceVersion check into ErrConcurrentModification.
+func replaceService(ctx context.Context, c *run.APIService, project string, svc *run.Service, opts DeployOptions) (*run.Service, error) {
+	call := c.Namespaces.Services.ReplaceService(
+		fmt.Sprintf("namespaces/%s/services/%s", project, svc.Metadata.Name), svc).Context(ctx)
+	if opts.DryRun {
+		call.DryRun("all")
+	}
+	out, err := call.Do()
+	if err != nil {
+		if opts.DryRun {
+			if verr := dryRunError(err); verr != nil {
+				return nil, verr
+			}
+		}
+		if v := ParseGoogleAPIError(err); v != nil && v.HTTPCode == http.StatusConflict {
+			return nil, fmt.Errorf("%w: %w", ErrConcurrentModification, apiError(err))
+		}
+		return nil, fmt.Errorf("failed to replace service: %w", apiError(err))
+	}
+	return out, nil
+}
+
+// dryRunError turns the rejection of a dry-run request into a
+// *ValidationError, or returns nil if err is not a validation failure.
+func dryRunError(err error) error {
+	v := ParseGoogleAPIError(err)
+	if v == nil || (v.HTTPCode != http.StatusBadRequest && v.HTTPCode != http.StatusUnprocessableEntity) {
+		return nil
+	}
+	var problems []string
+	for _, e := range v.err.Errors {
+		problems = append(problems, e.Message)
+	}
+	if len(problems) == 0 {
+		problems = append(problems, v.Message)
+	}
+	return &ValidationError{Problems: problems}
+}
+
+// EnsureServiceExists creates svc if there's no service with its name yet,
+// and otherwise returns the existing service untouched. The returned bool
+// reports whether the service was created by this call.
+//
+// Losing a race to create the service to another caller is not an error,
+// the service created by the other caller is returned instead.
+func EnsureServiceExists(ctx context.Context, c *run.APIService, region, project string, svc *run.Service) (*run.Service, bool, error) {
+	if svc.Metadata == nil || svc.Metadata.Name == "" {
+		return nil, false, fmt.Errorf("service name is not set")
+	}
+	name := svc.Metadata.Name
+	cur, err := getService(c, region, project, name)
+	if err == nil {
+		return cur, false, nil
+	}
+	if v := ParseGoogleAPIError(err); v == nil || v.HTTPCode != http.StatusNotFound {
+		return nil, false, fmt.Errorf("failed to get service: %w", err)
+	}
+
+	out, err := c.Namespaces.Services.Create("namespaces/"+project, svc).Context(ctx).Do()
+	if err == nil {
+		return out, true, nil
+	}
+	if v := ParseGoogleAPIError(err); v == nil || v.HTTPCode != http.StatusConflict {
+		return nil, false, fmt.Errorf("failed to create service: %w", apiError(err))
+	}
+	cur, err = getService(c, region, project, name)
+	if err != nil {
+		return nil, false, fmt.Errorf("service was created concurrently but failed to get it: %w", err)
+	}
+	return cur, false, nil
+}
+
+// OpKind tells what CreateOrUpdateService did.
+type OpKind int
+
+const (
+	OpCreated OpKind = iota + 1
+	OpUpdated
+)
+
+func (k OpKind) String() string {
+	switch k {
+	case OpCreated:
+		return "created"
+	case OpUpdated:
+		return "updated"
+	}
+	return fmt.Sprintf("OpKind(%d)", int(k))
+}
+
+// CreateOrUpdateService creates svc, or replaces the existing service with
+// it if the create call reports the service exists, such as when another
+// deployment created it first. Unlike DeployService, the update is always
+// based on the latest version of the service, discarding the
+// resourceVersion of svc.
+func CreateOrUpdateService(ctx context.Context, c *run.APIService, region, project string, svc *run.Service) (*run.Service, OpKind, error) {
+	if svc.Metadata == nil || svc.Metadata.Name == "" {
+		return nil, 0, fmt.Errorf("service name is not set")
+	}
+	out, err := c.Namespaces.Services.Create("namespaces/"+project, svc).Context(ctx).Do()
+	if err == nil {
+		return out, OpCreated, nil
+	}
+	if v := ParseGoogleAPIError(err); v == nil || v.HTTPCode != http.StatusConflict {
+		return nil, 0, fmt.Errorf("failed to create service: %w", apiError(err))
+	}
+
+	cur, err := getService(c, region, project, svc.Metadata.Name)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get current service: %w", err)
+	}
+	desired := *svc
+	meta := *svc.Metadata
+	meta.ResourceVersion = cur.Metadata.ResourceVersion
+	desired.Metadata = &meta
+	out, err = replaceService(ctx, c, project, &desired, DeployOptions{})
+	if err != nil {
+		return nil, 0, err
+	}
+	return out, OpUpdated, nil
+}
+
+// DeleteService issues the delete call for the service. The deletion
+// happens asynchronously and the service might still be visible for a while.
+func DeleteService(ctx context.Context, c *run.APIService, region, project, name string) error {
+	_, err := c.Namespaces.Services.Delete(fmt.Sprintf("namespaces/%s/services/%s", project, name)).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to delete service: %w", apiError(err))
+	}
+	return nil
+}
+
+// ListServices returns the services in the region matching labelSelector
+// (such as "env=staging"), or all of them if it's empty, sorted by name.
+func ListServices(ctx context.Context, c *run.APIService, region, project string, labelSelector string) ([]*run.Service, error) {
+	var out []*run.Service
+	call := c.Namespaces.Services.List("namespaces/" + project).Context(ctx)
+	if labelSelector != "" {
+		call.LabelSelector(labelSelector)
+	}
+	for token := ""; ; {
+		resp, err := call.Continue(token).Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list services: %w", apiError(err))
+		}
+		out = append(out, resp.Items...)
+		if resp.Metadata == nil || resp.Metadata.Continue == "" {
+			break
+		}
+		token = resp.Metadata.Continue
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Metadata.Name < out[j].Metadata.Name
+	})
+	return out, nil
+}
+
+// CopyService creates a service named dstName in dstRegion with the same
+// configuration as srcName in srcRegion. dstClient is only used if the
+// regions differ, and can be nil otherwise.
+//
+// Revisions are not copied over, so the copy sends all its traffic to its
+// latest revision regardless of the traffic split of the source.
+func CopyService(ctx context.Context, srcClient, dstClient *run.APIService, srcRegion, dstRegion, project, srcName, dstName string) error {
+	if srcRegion == dstRegion {
+		dstClient = srcClient
+	}
+	src, err := getService(srcClient, srcRegion, project, srcName)
+	if err != nil {
+		return fmt.Errorf("failed to get source service: %w", err)
+	}
+	svc, err := copyService(src)
+	if err != nil {
+		return err
+	}
+	stripServerFields(svc)
+	svc.Metadata.Name = dstName
+	delete(svc.Metadata.Labels, "cloud.googleapis.com/location")
+
+	// revision names must be prefixed with the service name.
+	if tmpl := svc.Spec.Template; tmpl != nil && tmpl.Metadata != nil && tmpl.Metadata.Name != "" {
+		if strings.HasPrefix(tmpl.Metadata.Name, srcName+"-") {
+			tmpl.Metadata.Name = dstName + strings.TrimPrefix(tmpl.Metadata.Name, srcName)
+		} else {
+			tmpl.Metadata.Name = ""
+		}
+	}
+	svc.Spec.Traffic = []*run.TrafficTarget{{LatestRevision: true, Percent: 100}}
+
+	if _, err := dstClient.Namespaces.Services.Create("namespaces/"+project, svc).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to create service: %w", apiError(err))
+	}
+	return nil
+}
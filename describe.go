@@ -0,0 +1,156 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/api/run/v1"
+)
+
+// sensitiveEnvRe matches names of environment variables whose values should
+// not end up in logs.
+var sensitiveEnvRe = regexp.MustCompile(`(?i)(secret|passw|token|key|credential|private|auth)`)
+
+// DescribeService writes a human-readable summary of svc to w. The output
+// only depends on svc, so it can be compared against golden files.
+//
+// Whether the service is public is part of its IAM policy rather than the
+// service object, use DescribeServiceWithPolicy to include it.
+func DescribeService(w io.Writer, svc *run.Service) error {
+	return DescribeServiceWithPolicy(w, svc, nil)
+}
+
+// DescribeServiceWithPolicy is DescribeService that also reports whether
+// policy (as returned by GetIAMPolicy) lets anyone invoke the service.
+func DescribeServiceWithPolicy(w io.Writer, svc *run.Service, policy *run.Policy) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	meta := svc.Metadata
+	if meta == nil {
+		meta = &run.ObjectMeta{}
+	}
+	status := svc.Status
+	if status == nil {
+		status = &run.ServiceStatus{}
+	}
+	var url string
+	if status.Address != nil {
+		url = status.Address.Url
+	}
+	fmt.Fprintf(tw, "Name:\t%s\n", meta.Name)
+	fmt.Fprintf(tw, "Region:\t%s\n", meta.Labels["cloud.googleapis.com/location"])
+	fmt.Fprintf(tw, "URL:\t%s\n", url)
+	fmt.Fprintf(tw, "Latest revision:\t%s\n", status.LatestCreatedRevisionName)
+	fmt.Fprintf(tw, "Latest ready revision:\t%s\n", status.LatestReadyRevisionName)
+	public := "unknown"
+	if policy != nil {
+		public = fmt.Sprint(isPublicPolicy(policy))
+	}
+	fmt.Fprintf(tw, "Public:\t%s\n", public)
+
+	fmt.Fprintln(tw, "Conditions:")
+	for _, c := range status.Conditions {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", c.Type, c.Status, c.Reason)
+	}
+
+	fmt.Fprintln(tw, "Traffic:")
+	if svc.Spec != nil {
+		for _, t := range svc.Spec.Traffic {
+			rev := t.RevisionName
+			if t.LatestRevision {
+				rev = "LATEST"
+			}
+			tag := ""
+			if t.Tag != "" {
+				tag = "tag=" + t.Tag
+			}
+			fmt.Fprintf(tw, "  %s\t%d%%\t%s\n", rev, t.Percent, tag)
+		}
+	}
+
+	var annotations map[string]string
+	var containers []*run.Container
+	if svc.Spec != nil && svc.Spec.Template != nil {
+		if m := svc.Spec.Template.Metadata; m != nil {
+			annotations = m.Annotations
+		}
+		if s := svc.Spec.Template.Spec; s != nil {
+			containers = s.Containers
+		}
+	}
+	fmt.Fprintf(tw, "Min instances:\t%s\n", orDefault(annotations["autoscaling.knative.dev/minScale"], "default"))
+	fmt.Fprintf(tw, "Max instances:\t%s\n", orDefault(annotations["autoscaling.knative.dev/maxScale"], "default"))
+
+	for _, c := range containers {
+		name := c.Name
+		if name == "" {
+			name = "(unnamed)"
+		}
+		fmt.Fprintf(tw, "Container %s:\n", name)
+		fmt.Fprintf(tw, "  Image:\t%s\n", c.Image)
+		var limits map[string]string
+		if c.Resources != nil {
+			limits = c.Resources.Limits
+		}
+		fmt.Fprintf(tw, "  CPU:\t%s\n", orDefault(limits["cpu"], "default"))
+		fmt.Fprintf(tw, "  Memory:\t%s\n", orDefault(limits["memory"], "default"))
+		if len(c.Env) > 0 {
+			fmt.Fprintln(tw, "  Env:")
+		}
+		for _, e := range c.Env {
+			fmt.Fprintf(tw, "    %s\t%s\n", e.Name, envDisplayValue(e))
+		}
+	}
+	return tw.Flush()
+}
+
+// envDisplayValue returns the value of e to show to humans, hiding values
+// that look like credentials.
+func envDisplayValue(e *run.EnvVar) string {
+	if e.ValueFrom != nil && e.ValueFrom.SecretKeyRef != nil {
+		return fmt.Sprintf("<secret %s/%s>", e.ValueFrom.SecretKeyRef.Name, e.ValueFrom.SecretKeyRef.Key)
+	}
+	if e.Value != "" && sensitiveEnvRe.MatchString(e.Name) {
+		return "<redacted>"
+	}
+	return e.Value
+}
+
+// isPublicPolicy reports whether policy grants roles/run.invoker to
+// allUsers unconditionally.
+func isPublicPolicy(policy *run.Policy) bool {
+	for _, b := range policy.Bindings {
+		if b.Role != "roles/run.invoker" || b.Condition != nil {
+			continue
+		}
+		for _, m := range b.Members {
+			if m == "allUsers" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func orDefault(s, def string) string {
+	if strings.TrimSpace(s) == "" {
+		return def
+	}
+	return s
+}
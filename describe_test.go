@@ -0,0 +1,170 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/api/run/v1"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// checkGolden compares got with the golden file testdata/name, or writes
+// it there with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s (run with -update to accept it):\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// goldenService returns a deployed service using most of the settings
+// that show up in descriptions and exports.
+func goldenService() *run.Service {
+	return &run.Service{
+		ApiVersion: "serving.knative.dev/v1",
+		Kind:       "Service",
+		Metadata: &run.ObjectMeta{
+			Name:      "api",
+			Namespace: "123456789",
+			Labels: map[string]string{
+				"cloud.googleapis.com/location": "us-central1",
+				"team":                          "payments",
+			},
+			Annotations: map[string]string{
+				"run.googleapis.com/ingress": "internal-and-cloud-load-balancing",
+			},
+		},
+		Spec: &run.ServiceSpec{
+			Template: &run.RevisionTemplate{
+				Metadata: &run.ObjectMeta{
+					Name: "api-v2",
+					Annotations: map[string]string{
+						"autoscaling.knative.dev/minScale":     "1",
+						"autoscaling.knative.dev/maxScale":     "10",
+						"run.googleapis.com/cpu-throttling":    "false",
+						"run.googleapis.com/vpc-access-egress": "private-ranges-only",
+					},
+				},
+				Spec: &run.RevisionSpec{
+					ContainerConcurrency: 40,
+					TimeoutSeconds:       120,
+					ServiceAccountName:   "api@p.iam.gserviceaccount.com",
+					Containers: []*run.Container{{
+						Name:  "app",
+						Image: "us-docker.pkg.dev/p/r/api:v2",
+						Ports: []*run.ContainerPort{{ContainerPort: 8080}},
+						Resources: &run.ResourceRequirements{
+							Limits: map[string]string{"cpu": "2", "memory": "1Gi"},
+						},
+						Env: []*run.EnvVar{
+							{Name: "LOG_LEVEL", Value: "info"},
+							{Name: "API_TOKEN", Value: "hunter2"},
+							{Name: "DB_PASSWORD", ValueFrom: &run.EnvVarSource{
+								SecretKeyRef: &run.SecretKeySelector{Name: "db-password", Key: "latest"},
+							}},
+						},
+					}, {
+						Name:  "proxy",
+						Image: "gcr.io/p/proxy:1.0",
+					}},
+				},
+			},
+			Traffic: []*run.TrafficTarget{
+				{RevisionName: "api-v1", Percent: 90},
+				{LatestRevision: true, Percent: 10},
+				{RevisionName: "api-v1", Tag: "stable"},
+			},
+		},
+		Status: &run.ServiceStatus{
+			Address:                   &run.Addressable{Url: "https://api-abc123-uc.a.run.app"},
+			LatestCreatedRevisionName: "api-v2",
+			LatestReadyRevisionName:   "api-v2",
+			Conditions: []*run.GoogleCloudRunV1Condition{
+				{Type: "Ready", Status: "True"},
+				{Type: "ConfigurationsReady", Status: "True"},
+				{Type: "RoutesReady", Status: "False", Reason: "RevisionFailed"},
+			},
+		},
+	}
+}
+
+func TestDescribeService(t *testing.T) {
+	tests := []struct {
+		golden string
+		svc    *run.Service
+		policy *run.Policy
+	}{
+		{
+			golden: "describe.golden",
+			svc:    goldenService(),
+		},
+		{
+			golden: "describe_public.golden",
+			svc:    goldenService(),
+			policy: &run.Policy{Bindings: []*run.Binding{{
+				Role:    "roles/run.invoker",
+				Members: []string{"allUsers"},
+			}}},
+		},
+		{
+			golden: "describe_empty.golden",
+			svc:    &run.Service{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := DescribeServiceWithPolicy(&buf, tt.svc, tt.policy); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, tt.golden, buf.Bytes())
+		})
+	}
+}
+
+func TestIsPublicPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		binding *run.Binding
+		want    bool
+	}{
+		{"allUsers invoker", &run.Binding{Role: "roles/run.invoker", Members: []string{"allUsers"}}, true},
+		{"other role", &run.Binding{Role: "roles/run.viewer", Members: []string{"allUsers"}}, false},
+		{"authenticated users", &run.Binding{Role: "roles/run.invoker", Members: []string{"allAuthenticatedUsers"}}, false},
+		{"conditional", &run.Binding{Role: "roles/run.invoker", Members: []string{"allUsers"}, Condition: &run.Expr{Expression: "false"}}, false},
+	}
+	for _, tt := range tests {
+		if got := isPublicPolicy(&run.Policy{Bindings: []*run.Binding{tt.binding}}); got != tt.want {
+			t.Errorf("isPublicPolicy(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"google.golang.org/api/run/v1"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+type diffLine struct {
+	op   byte // ' ', '-' or '+'
+	text string
+}
+
+// DiffService returns a unified diff between the YAML representations of
+// two services, or an empty string if they are equivalent. Fields managed by
+// the server are left out, so a freshly fetched service can be compared
+// with the one about to be deployed.
+func DiffService(oldSvc, newSvc *run.Service) (string, error) {
+	a, err := ExportServiceYAML(oldSvc)
+	if err != nil {
+		return "", err
+	}
+	b, err := ExportServiceYAML(newSvc)
+	if err != nil {
+		return "", err
+	}
+	if a == b {
+		return "", nil
+	}
+
+	// diff line by line, by having each distinct line stand for one rune.
+	index := make(map[string]rune)
+	encode := func(text string) []rune {
+		var out []rune
+		for _, l := range strings.SplitAfter(text, "\n") {
+			if l == "" {
+				continue
+			}
+			r, ok := index[l]
+			if !ok {
+				r = lineRune(len(index))
+				index[l] = r
+			}
+			out = append(out, r)
+		}
+		return out
+	}
+	ra, rb := encode(a), encode(b)
+	decode := make(map[rune]string, len(index))
+	for l, r := range index {
+		decode[r] = strings.TrimSuffix(l, "\n")
+	}
+
+	var lines []diffLine
+	for _, d := range diffmatchpatch.New().DiffMainRunes(ra, rb, false) {
+		op := byte(' ')
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			op = '-'
+		case diffmatchpatch.DiffInsert:
+			op = '+'
+		}
+		for _, r := range d.Text {
+			lines = append(lines, diffLine{op, decode[r]})
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("--- old\n+++ new\n")
+	oldNo, newNo := 1, 1 // line numbers at index i
+	for i := 0; i < len(lines); {
+		// skip to the next change, then back up for the leading context.
+		j := i
+		for j < len(lines) && lines[j].op == ' ' {
+			j++
+		}
+		if j == len(lines) {
+			break
+		}
+		start := j - diffContext
+		if start < i {
+			start = i
+		}
+		for k := i; k < start; k++ {
+			oldNo++
+			newNo++
+		}
+		// extend the hunk while changes are close enough to merge.
+		last := j
+		for k := j; k < len(lines) && k-last <= 2*diffContext; k++ {
+			if lines[k].op != ' ' {
+				last = k
+			}
+		}
+		end := last + diffContext + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		oldLen, newLen := 0, 0
+		for _, l := range lines[start:end] {
+			if l.op != '+' {
+				oldLen++
+			}
+			if l.op != '-' {
+				newLen++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(oldNo, oldLen), hunkRange(newNo, newLen))
+		for _, l := range lines[start:end] {
+			sb.WriteByte(l.op)
+			sb.WriteString(l.text)
+			sb.WriteByte('\n')
+		}
+		oldNo += oldLen
+		newNo += newLen
+		i = end
+	}
+	return sb.String(), nil
+}
+
+// hunkRange formats the start,length of a hunk, where empty ranges refer
+// to the line before them as in GNU diff.
+func hunkRange(start, length int) string {
+	if length == 0 {
+		start--
+	}
+	return fmt.Sprintf("%d,%d", start, length)
+}
+
+// lineRune maps the i-th distinct line to a rune, skipping the surrogate
+// range which does not survive the conversion to string.
+func lineRune(i int) rune {
+	r := rune(i + 1)
+	if r >= 0xD800 {
+		r += 0x800
+	}
+	return r
+}
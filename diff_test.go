@@ -0,0 +1,163 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/api/run/v1"
+)
+
+// diffTestService returns a service running image with the environment
+// variables in vars, set in order with values of "1" unless given as
+// "NAME=value".
+func diffTestService(image string, vars ...string) *run.Service {
+	c := &run.Container{Image: image}
+	for _, v := range vars {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok {
+			value = "1"
+		}
+		c.Env = append(c.Env, &run.EnvVar{Name: name, Value: value})
+	}
+	return &run.Service{
+		ApiVersion: "serving.knative.dev/v1",
+		Kind:       "Service",
+		Metadata:   &run.ObjectMeta{Name: "app"},
+		Spec: &run.ServiceSpec{Template: &run.RevisionTemplate{
+			Spec: &run.RevisionSpec{Containers: []*run.Container{c}},
+		}},
+	}
+}
+
+func TestDiffService(t *testing.T) {
+	letters := []string{"A", "B", "C", "D", "E", "F", "G", "H", "I", "J", "K", "L"}
+	changed := append([]string{"A=2"}, letters[1:11]...)
+	changed = append(changed, "L=2")
+
+	tests := []struct {
+		name     string
+		old, new *run.Service
+		want     string
+	}{
+		{
+			name: "equal",
+			old:  diffTestService("gcr.io/p/app:v1", "A", "B"),
+			new:  diffTestService("gcr.io/p/app:v1", "A", "B"),
+			want: "",
+		},
+		{
+			name: "server fields ignored",
+			old: func() *run.Service {
+				svc := diffTestService("gcr.io/p/app:v1")
+				svc.Metadata.ResourceVersion = "abc"
+				svc.Status = &run.ServiceStatus{LatestReadyRevisionName: "app-00001"}
+				return svc
+			}(),
+			new:  diffTestService("gcr.io/p/app:v1"),
+			want: "",
+		},
+		{
+			name: "nearby changes share a hunk",
+			old:  diffTestService("gcr.io/p/app:v1", "A", "B", "C"),
+			new:  diffTestService("gcr.io/p/app:v1", "A", "C=2", "D"),
+			want: `--- old
++++ new
+@@ -9,8 +9,8 @@
+       - env:
+         - name: A
+           value: "1"
+-        - name: B
+-          value: "1"
+         - name: C
++          value: "2"
++        - name: D
+           value: "1"
+         image: gcr.io/p/app:v1
+`,
+		},
+		{
+			name: "distant changes get their own hunks",
+			old:  diffTestService("gcr.io/p/app:v1", letters...),
+			new:  diffTestService("gcr.io/p/app:v2", changed...),
+			want: `--- old
++++ new
+@@ -8,7 +8,7 @@
+       containers:
+       - env:
+         - name: A
+-          value: "1"
++          value: "2"
+         - name: B
+           value: "1"
+         - name: C
+@@ -30,5 +30,5 @@
+         - name: K
+           value: "1"
+         - name: L
+-          value: "1"
+-        image: gcr.io/p/app:v1
++          value: "2"
++        image: gcr.io/p/app:v2
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DiffService(tt.old, tt.new)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("DiffService() =\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHunkRange(t *testing.T) {
+	tests := []struct {
+		start, length int
+		want          string
+	}{
+		{1, 3, "1,3"},
+		{10, 7, "10,7"},
+		{5, 0, "4,0"},
+		{1, 0, "0,0"},
+	}
+	for _, tt := range tests {
+		if got := hunkRange(tt.start, tt.length); got != tt.want {
+			t.Errorf("hunkRange(%d, %d) = %q, want %q", tt.start, tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestLineRune(t *testing.T) {
+	seen := make(map[rune]bool)
+	for i := 0; i < 0x10000; i++ {
+		r := lineRune(i)
+		if r >= 0xD800 && r <= 0xDFFF {
+			t.Fatalf("lineRune(%d) = %#x, a surrogate", i, r)
+		}
+		if seen[r] {
+			t.Fatalf("lineRune(%d) = %#x, already used", i, r)
+		}
+		seen[r] = true
+		if s := []rune(string(r)); len(s) != 1 || s[0] != r {
+			t.Fatalf("lineRune(%d) = %#x does not survive conversion to string", i, r)
+		}
+	}
+}
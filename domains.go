@@ -0,0 +1,120 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/run/v1"
+)
+
+// CreateDomainMapping maps domainName to the service, with a managed
+// certificate. It waits until the DNS records to create at your DNS
+// provider are known, and returns them in Status.ResourceRecords.
+//
+// The domain (or its parent) must be verified for the caller beforehand,
+// see https://cloud.google.com/run/docs/mapping-custom-domains.
+func CreateDomainMapping(ctx context.Context, c *run.APIService, region, project, name, domainName string) (*run.DomainMapping, error) {
+	dm := &run.DomainMapping{
+		ApiVersion: "domains.cloudrun.com/v1",
+		Kind:       "DomainMapping",
+		Metadata:   &run.ObjectMeta{Name: domainName},
+		Spec: &run.DomainMappingSpec{
+			RouteName:       name,
+			CertificateMode: "AUTOMATIC",
+		},
+	}
+	if _, err := c.Namespaces.Domainmappings.Create("namespaces/"+project, dm).Context(ctx).Do(); err != nil {
+		return nil, fmt.Errorf("failed to create domain mapping: %w", apiError(err))
+	}
+
+	opts := DefaultWaitOptions()
+	opts.PollInterval = time.Second * 2
+	err := pollWithBackoff(ctx, opts, func() (bool, error, error) {
+		v, err := GetDomainMapping(ctx, c, region, project, domainName)
+		if errors.Is(err, ErrNotFound) {
+			return false, nil, nil
+		} else if err != nil {
+			return false, err, nil
+		}
+		dm = v
+		return dm.Status != nil && len(dm.Status.ResourceRecords) > 0, nil, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dns records of domain mapping: %w", err)
+	}
+	return dm, nil
+}
+
+// GetDomainMapping returns the mapping of domainName, or ErrNotFound if
+// there's no such mapping.
+func GetDomainMapping(ctx context.Context, c *run.APIService, region, project, domainName string) (*run.DomainMapping, error) {
+	dm, err := c.Namespaces.Domainmappings.Get(fmt.Sprintf("namespaces/%s/domainmappings/%s", project, domainName)).Context(ctx).Do()
+	if err == nil {
+		return dm, nil
+	}
+	if v := ParseGoogleAPIError(err); v != nil && v.HTTPCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: domain mapping %q", ErrNotFound, domainName)
+	}
+	return nil, fmt.Errorf("failed to get domain mapping: %w", apiError(err))
+}
+
+// DeleteDomainMapping deletes the mapping of domainName. A mapping that
+// does not exist is not an error.
+func DeleteDomainMapping(ctx context.Context, c *run.APIService, region, project, domainName string) error {
+	_, err := c.Namespaces.Domainmappings.Delete(fmt.Sprintf("namespaces/%s/domainmappings/%s", project, domainName)).Context(ctx).Do()
+	if err == nil {
+		return nil
+	}
+	if v := ParseGoogleAPIError(err); v != nil && v.HTTPCode == http.StatusNotFound {
+		return nil
+	}
+	return fmt.Errorf("failed to delete domain mapping: %w", apiError(err))
+}
+
+// WaitForDomainMappingReady polls the mapping of domainName until it's
+// Ready, meaning the service is served at the domain with a valid
+// certificate. This takes as long as DNS changes take to propagate, so
+// ctx should allow for it. A not-yet-ready mapping is not considered
+// failed, as most of its errors go away once DNS is set up; the last
+// reported problem is included in the error if ctx expires.
+func WaitForDomainMappingReady(ctx context.Context, c *run.APIService, region, project, domainName string, opts WaitOptions) error {
+	var last *run.GoogleCloudRunV1Condition
+	err := pollWithBackoff(ctx, opts, func() (bool, error, error) {
+		dm, err := GetDomainMapping(ctx, c, region, project, domainName)
+		if err != nil {
+			return false, err, nil
+		}
+		if dm.Status == nil {
+			return false, nil, nil
+		}
+		for _, cond := range dm.Status.Conditions {
+			if cond.Type == "Ready" {
+				last = cond
+				return cond.Status == "True", nil, nil
+			}
+		}
+		return false, nil, nil
+	})
+	if err != nil && ctx.Err() != nil && last != nil {
+		return fmt.Errorf("domain mapping %q is not ready (status:%s) (reason:%s) %s: %w",
+			domainName, last.Status, last.Reason, last.Message, err)
+	}
+	return err
+}
@@ -0,0 +1,271 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/run/v1"
+)
+
+var secretNameRe = regexp.MustCompile(`^projects/([^/]+)/secrets/([^/]+)$`)
+
+// secretsAnnotation maps the names secrets are referenced by in a revision
+// to their full names, so that they can be in other projects.
+const secretsAnnotation = "run.googleapis.com/secrets"
+
+// secretAlias validates a projects/*/secrets/* resource name and returns
+// the name the revision template tmpl refers to the secret by. The alias
+// is recorded in the run.googleapis.com/secrets annotation of tmpl, so the
+// secret is looked up in its own project rather than in the project of the
+// service. It's the short name of the secret, unless that already refers
+// to another secret.
+func secretAlias(tmpl *run.RevisionTemplate, secretName string) (string, error) {
+	m := secretNameRe.FindStringSubmatch(secretName)
+	if m == nil {
+		return "", fmt.Errorf("invalid secret name %q, expected projects/*/secrets/*", secretName)
+	}
+	project, name := m[1], m[2]
+	if tmpl.Metadata == nil {
+		tmpl.Metadata = &run.ObjectMeta{}
+	}
+	if tmpl.Metadata.Annotations == nil {
+		tmpl.Metadata.Annotations = make(map[string]string)
+	}
+	aliases := secretAliases(tmpl.Metadata.Annotations)
+	var entries []string
+	if v := tmpl.Metadata.Annotations[secretsAnnotation]; v != "" {
+		entries = strings.Split(v, ",")
+	}
+	// secrets referenced without an alias are in the service's project, so
+	// their names can't be taken.
+	if tmpl.Spec != nil {
+		for _, v := range tmpl.Spec.Volumes {
+			if v.Secret != nil {
+				if _, ok := aliases[v.Secret.SecretName]; !ok {
+					aliases[v.Secret.SecretName] = ""
+				}
+			}
+		}
+		for _, c := range tmpl.Spec.Containers {
+			for _, e := range c.Env {
+				if e.ValueFrom == nil || e.ValueFrom.SecretKeyRef == nil {
+					continue
+				}
+				if _, ok := aliases[e.ValueFrom.SecretKeyRef.Name]; !ok {
+					aliases[e.ValueFrom.SecretKeyRef.Name] = ""
+				}
+			}
+		}
+	}
+	for _, alias := range []string{name, name + "-" + project} {
+		full, ok := aliases[alias]
+		if full == secretName {
+			return alias, nil
+		}
+		if !ok {
+			entries = append(entries, alias+":"+secretName)
+			tmpl.Metadata.Annotations[secretsAnnotation] = strings.Join(entries, ",")
+			return alias, nil
+		}
+	}
+	return "", fmt.Errorf("secret aliases %q and %q are already used by other secrets", name, name+"-"+project)
+}
+
+// secretAliases returns the alias to full secret name mapping recorded in
+// the run.googleapis.com/secrets annotation of a revision template.
+func secretAliases(annotations map[string]string) map[string]string {
+	out := make(map[string]string)
+	if v := annotations[secretsAnnotation]; v != "" {
+		for _, e := range strings.Split(v, ",") {
+			alias, full, _ := strings.Cut(e, ":")
+			out[alias] = full
+		}
+	}
+	return out
+}
+
+// AddSecretEnvVar exposes a Secret Manager secret version ("latest" or a
+// version number) to the container of the revision template tmpl as an
+// environment variable. The secret may be in another project than the
+// service, as it's referenced through an alias in the
+// run.googleapis.com/secrets annotation of tmpl.
+func AddSecretEnvVar(tmpl *run.RevisionTemplate, container *run.Container, envName, secretName, secretVersion string) error {
+	if !envVarNameRe.MatchString(envName) {
+		return fmt.Errorf("invalid environment variable name %q", envName)
+	}
+	for _, e := range container.Env {
+		if e.Name == envName {
+			return fmt.Errorf("environment variable %q is already set", envName)
+		}
+	}
+	if secretVersion == "" {
+		return fmt.Errorf("secret version is not set")
+	}
+	name, err := secretAlias(tmpl, secretName)
+	if err != nil {
+		return err
+	}
+	container.Env = append(container.Env, &run.EnvVar{
+		Name: envName,
+		ValueFrom: &run.EnvVarSource{
+			SecretKeyRef: &run.SecretKeySelector{Name: name, Key: secretVersion},
+		},
+	})
+	return nil
+}
+
+// AddSecretVolumeMount mounts a Secret Manager secret version as a file
+// named after the secret in the mountPath directory of the container of
+// the revision template tmpl. Volumes are declared on the revision spec,
+// which is why the template is needed alongside the container. As with
+// AddSecretEnvVar, the secret may be in another project. Mounting the same
+// secret version again reuses its volume.
+func AddSecretVolumeMount(tmpl *run.RevisionTemplate, container *run.Container, mountPath, secretName, secretVersion string) error {
+	if secretVersion == "" {
+		return fmt.Errorf("secret version is not set")
+	}
+	if !strings.HasPrefix(mountPath, "/") {
+		return fmt.Errorf("mount path %q must be absolute", mountPath)
+	}
+	for _, m := range container.VolumeMounts {
+		if m.MountPath == mountPath {
+			return fmt.Errorf("mount path %q is already used by volume %q", mountPath, m.Name)
+		}
+	}
+	if tmpl.Spec == nil {
+		tmpl.Spec = &run.RevisionSpec{}
+	}
+	name, err := secretAlias(tmpl, secretName)
+	if err != nil {
+		return err
+	}
+	source := &run.SecretVolumeSource{
+		SecretName: name,
+		Items:      []*run.KeyToPath{{Key: secretVersion, Path: name}},
+	}
+	volume := secretVolumeName(tmpl.Spec, source)
+	if volume == "" {
+		volume = uniqueVolumeName(tmpl.Spec, "secret-"+strings.ToLower(strings.ReplaceAll(name, "_", "-")))
+		tmpl.Spec.Volumes = append(tmpl.Spec.Volumes, &run.Volume{Name: volume, Secret: source})
+	}
+	container.VolumeMounts = append(container.VolumeMounts, &run.VolumeMount{
+		Name:      volume,
+		MountPath: mountPath,
+		ReadOnly:  true,
+	})
+	return nil
+}
+
+// secretVolumeName returns the name of the volume of spec with the given
+// secret source, or "" if there's none.
+func secretVolumeName(spec *run.RevisionSpec, source *run.SecretVolumeSource) string {
+	for _, v := range spec.Volumes {
+		if v.Secret != nil && reflect.DeepEqual(v.Secret, source) {
+			return v.Name
+		}
+	}
+	return ""
+}
+
+// uniqueVolumeName returns name, or name with a number appended if spec
+// already has a volume called that.
+func uniqueVolumeName(spec *run.RevisionSpec, name string) string {
+	used := make(map[string]bool, len(spec.Volumes))
+	for _, v := range spec.Volumes {
+		used[v.Name] = true
+	}
+	out := name
+	for i := 2; used[out]; i++ {
+		out = fmt.Sprintf("%s-%d", name, i)
+	}
+	return out
+}
+
+var envVarNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ConfigureEnvVars sets the plain-text environment variables in vars on the
+// container. Variables not mentioned in vars are kept, and variables mapped
+// to an empty string are removed. Calling it again with the same vars
+// leaves the container unchanged.
+func ConfigureEnvVars(container *run.Container, vars map[string]string) error {
+	var invalid []string
+	for k := range vars {
+		if !envVarNameRe.MatchString(k) {
+			invalid = append(invalid, fmt.Sprintf("invalid environment variable name %q", k))
+		}
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return &ValidationError{Problems: invalid}
+	}
+
+	env := make([]*run.EnvVar, 0, len(container.Env)+len(vars))
+	seen := make(map[string]bool)
+	for _, e := range container.Env {
+		v, ok := vars[e.Name]
+		if !ok {
+			env = append(env, e)
+			continue
+		}
+		if seen[e.Name] {
+			continue // drop duplicates of a variable being set
+		}
+		seen[e.Name] = true
+		if v != "" {
+			env = append(env, &run.EnvVar{Name: e.Name, Value: v})
+		}
+	}
+	// new variables go last, in a stable order.
+	var added []string
+	for k, v := range vars {
+		if !seen[k] && v != "" {
+			added = append(added, k)
+		}
+	}
+	sort.Strings(added)
+	for _, k := range added {
+		env = append(env, &run.EnvVar{Name: k, Value: vars[k]})
+	}
+	container.Env = env
+	return nil
+}
+
+// GetEnvironmentVariables returns the environment variables of the
+// container at containerIndex in the revision template of svc. Variables
+// backed by Secret Manager are returned as "<secretRef:name/version>"
+// instead of their value, which is not part of the service anyway.
+func GetEnvironmentVariables(svc *run.Service, containerIndex int) (map[string]string, error) {
+	var containers []*run.Container
+	if svc.Spec != nil && svc.Spec.Template != nil && svc.Spec.Template.Spec != nil {
+		containers = svc.Spec.Template.Spec.Containers
+	}
+	if containerIndex < 0 || containerIndex >= len(containers) {
+		return nil, fmt.Errorf("%w: container %d, service has %d", ErrIndexOutOfRange, containerIndex, len(containers))
+	}
+	out := make(map[string]string, len(containers[containerIndex].Env))
+	for _, e := range containers[containerIndex].Env {
+		if e.ValueFrom != nil && e.ValueFrom.SecretKeyRef != nil {
+			out[e.Name] = fmt.Sprintf("<secretRef:%s/%s>", e.ValueFrom.SecretKeyRef.Name, e.ValueFrom.SecretKeyRef.Key)
+			continue
+		}
+		out[e.Name] = e.Value
+	}
+	return out, nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/api/run/v1"
+)
+
+func TestAddSecretEnvVar(t *testing.T) {
+	tmpl := &run.RevisionTemplate{Spec: &run.RevisionSpec{Containers: []*run.Container{{
+		Env: []*run.EnvVar{{
+			Name:      "LOCAL",
+			ValueFrom: &run.EnvVarSource{SecretKeyRef: &run.SecretKeySelector{Name: "db", Key: "1"}},
+		}},
+	}}}}
+	c := tmpl.Spec.Containers[0]
+
+	if err := AddSecretEnvVar(tmpl, c, "API_KEY", "projects/p1/secrets/api", "latest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddSecretEnvVar(tmpl, c, "API_KEY_AGAIN", "projects/p1/secrets/api", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddSecretEnvVar(tmpl, c, "API_KEY_OTHER", "projects/p2/secrets/api", "latest"); err != nil {
+		t.Fatal(err)
+	}
+	// "db" is already referenced as a secret of the service's own project.
+	if err := AddSecretEnvVar(tmpl, c, "DB", "projects/p2/secrets/db", "latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"LOCAL":         "db",
+		"API_KEY":       "api",
+		"API_KEY_AGAIN": "api",
+		"API_KEY_OTHER": "api-p2",
+		"DB":            "db-p2",
+	}
+	for _, e := range c.Env {
+		if got := e.ValueFrom.SecretKeyRef.Name; got != want[e.Name] {
+			t.Errorf("secret of %s = %q, want %q", e.Name, got, want[e.Name])
+		}
+	}
+	const wantAnnotation = "api:projects/p1/secrets/api,api-p2:projects/p2/secrets/api,db-p2:projects/p2/secrets/db"
+	if got := tmpl.Metadata.Annotations[secretsAnnotation]; got != wantAnnotation {
+		t.Errorf("%s = %q, want %q", secretsAnnotation, got, wantAnnotation)
+	}
+}
+
+func TestAddSecretEnvVarErrors(t *testing.T) {
+	tests := []struct {
+		name                         string
+		envName, secretName, version string
+	}{
+		{"invalid env name", "1KEY", "projects/p/secrets/s", "latest"},
+		{"env already set", "SET", "projects/p/secrets/s", "latest"},
+		{"invalid secret name", "KEY", "s", "latest"},
+		{"no version", "KEY", "projects/p/secrets/s", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &run.Container{Env: []*run.EnvVar{{Name: "SET", Value: "v"}}}
+			tmpl := &run.RevisionTemplate{Spec: &run.RevisionSpec{Containers: []*run.Container{c}}}
+			if err := AddSecretEnvVar(tmpl, c, tt.envName, tt.secretName, tt.version); err == nil {
+				t.Fatal("AddSecretEnvVar() succeeded, want error")
+			}
+			if len(c.Env) != 1 || tmpl.Metadata != nil {
+				t.Errorf("AddSecretEnvVar() changed the template despite failing")
+			}
+		})
+	}
+}
+
+func TestAddSecretVolumeMount(t *testing.T) {
+	c := &run.Container{}
+	tmpl := &run.RevisionTemplate{Spec: &run.RevisionSpec{Containers: []*run.Container{c}}}
+	for _, m := range []struct{ path, secret, version string }{
+		{"/etc/a", "projects/p1/secrets/cfg", "1"},
+		{"/etc/b", "projects/p1/secrets/cfg", "1"},
+		{"/etc/c", "projects/p1/secrets/cfg", "2"},
+		{"/etc/d", "projects/p2/secrets/cfg", "1"},
+	} {
+		if err := AddSecretVolumeMount(tmpl, c, m.path, m.secret, m.version); err != nil {
+			t.Fatalf("AddSecretVolumeMount(%s, %s) = %v", m.path, m.secret, err)
+		}
+	}
+	if err := AddSecretVolumeMount(tmpl, c, "/etc/a", "projects/p1/secrets/other", "1"); err == nil {
+		t.Error("AddSecretVolumeMount() succeeded for a mount path in use")
+	}
+
+	got := make(map[string]string)
+	for _, m := range c.VolumeMounts {
+		got[m.MountPath] = m.Name
+	}
+	want := map[string]string{
+		"/etc/a": "secret-cfg",
+		"/etc/b": "secret-cfg",
+		"/etc/c": "secret-cfg-2",
+		"/etc/d": "secret-cfg-p2",
+	}
+	for path, volume := range want {
+		if got[path] != volume {
+			t.Errorf("volume of %s = %q, want %q", path, got[path], volume)
+		}
+	}
+	if len(tmpl.Spec.Volumes) != 3 {
+		t.Errorf("got %d volumes, want 3", len(tmpl.Spec.Volumes))
+	}
+}
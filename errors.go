@@ -0,0 +1,197 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// ErrNotFound is returned when the requested resource does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrConcurrentModification is returned when the service was modified by
+	// someone else between reading it and writing it back.
+	ErrConcurrentModification = errors.New("service was modified concurrently")
+
+	// ErrURLNotAvailable is returned when a service did not get a URL in
+	// time.
+	ErrURLNotAvailable = errors.New("service url is not available")
+
+	// ErrRevisionNotFound is returned when a revision does not exist or does
+	// not belong to the service in question.
+	ErrRevisionNotFound = errors.New("revision not found")
+
+	// ErrRevisionFailed is returned when a revision is in a terminal failure
+	// state and cannot serve traffic.
+	ErrRevisionFailed = errors.New("revision has failed")
+
+	// ErrCanaryFailed is returned when a canary revision was rolled back.
+	ErrCanaryFailed = errors.New("canary failed")
+
+	// ErrInvalidServiceAccount is returned for malformed service account
+	// emails.
+	ErrInvalidServiceAccount = errors.New("invalid service account")
+
+	// ErrInvalidIngressMode is returned for unknown ingress settings.
+	ErrInvalidIngressMode = errors.New("invalid ingress mode")
+
+	// ErrBindingNotFound is returned when removing an IAM binding that
+	// does not exist.
+	ErrBindingNotFound = errors.New("iam binding not found")
+
+	// ErrTagHasTraffic is returned when removing a traffic tag from a target
+	// that still receives traffic.
+	ErrTagHasTraffic = errors.New("tag has traffic")
+
+	// ErrStillExists is returned when a deleted resource did not go away
+	// in time.
+	ErrStillExists = errors.New("resource still exists")
+
+	// ErrNoChanges is returned when the live service already matches the
+	// desired configuration and nothing was deployed.
+	ErrNoChanges = errors.New("no changes")
+
+	// ErrNoRevision is returned when a service has no revision of the
+	// requested kind yet.
+	ErrNoRevision = errors.New("no revision")
+
+	// ErrTagNotFound is returned when a service has no traffic target with
+	// the requested tag.
+	ErrTagNotFound = errors.New("tag not found")
+
+	// ErrNotSupportedInRegion is returned when a feature is not available in
+	// the region of the service.
+	ErrNotSupportedInRegion = errors.New("not supported in region")
+
+	// ErrInvalidTimeout is returned for request timeouts Cloud Run does not
+	// accept.
+	ErrInvalidTimeout = errors.New("invalid request timeout")
+
+	// ErrIndexOutOfRange is returned when referring to a container that
+	// does not exist.
+	ErrIndexOutOfRange = errors.New("index out of range")
+
+	// ErrIncompatibleWithConnector is returned when configuring Direct VPC
+	// egress on a service using a Serverless VPC Access connector.
+	ErrIncompatibleWithConnector = errors.New("incompatible with vpc access connector")
+
+	// ErrReservedHeader is returned for headers that Cloud Run sets itself.
+	ErrReservedHeader = errors.New("reserved header")
+
+	// ErrMultiplePorts is returned when a container would end up declaring
+	// more than the single port Cloud Run allows.
+	ErrMultiplePorts = errors.New("container can only declare one port")
+
+	// ErrFieldMissing is returned when a field to read is not set.
+	ErrFieldMissing = errors.New("field is missing")
+)
+
+// multiError collects the errors of independent best-effort operations.
+type multiError []error
+
+func (m multiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d error(s) occurred: %s", len(m), strings.Join(msgs, "; "))
+}
+
+// ValidationError reports every problem found in an input at once, so they
+// can all be fixed in one go.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "validation failed: " + strings.Join(e.Problems, "; ")
+}
+
+// CloudRunError is the normalized form of the errors returned by the
+// Google APIs. Functions in this package return errors wrapping it
+// whenever an API call fails, which can be retrieved with errors.As or
+// ParseGoogleAPIError.
+type CloudRunError struct {
+	HTTPCode int
+	Message  string
+	// Details are the error details returned by the API, such as field
+	// violations, each formatted as JSON.
+	Details []string
+
+	err *googleapi.Error
+}
+
+func (e *CloudRunError) Error() string {
+	return e.err.Error()
+}
+
+func (e *CloudRunError) Unwrap() error {
+	return e.err
+}
+
+// IsTransient reports whether retrying the failed call might succeed.
+// It's false for a nil *CloudRunError.
+func (e *CloudRunError) IsTransient() bool {
+	if e == nil {
+		return false
+	}
+	switch e.HTTPCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+// ParseGoogleAPIError extracts the API error from err, or returns nil if
+// err is not caused by a failed API call.
+func ParseGoogleAPIError(err error) *CloudRunError {
+	var cre *CloudRunError
+	if errors.As(err, &cre) {
+		return cre
+	}
+	var v *googleapi.Error
+	if !errors.As(err, &v) {
+		return nil
+	}
+	cre = &CloudRunError{HTTPCode: v.Code, Message: v.Message, err: v}
+	for _, e := range v.Errors {
+		cre.Details = append(cre.Details, fmt.Sprintf("%s: %s", e.Reason, e.Message))
+	}
+	for _, d := range v.Details {
+		if b, err := json.Marshal(d); err == nil {
+			cre.Details = append(cre.Details, string(b))
+		}
+	}
+	if cre.Message == "" && len(v.Errors) > 0 {
+		cre.Message = v.Errors[0].Message
+	}
+	return cre
+}
+
+// apiError converts errors of API calls to *CloudRunError, and returns
+// other errors (including nil) unchanged.
+func apiError(err error) error {
+	if e := ParseGoogleAPIError(err); e != nil {
+		return e
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/run/v1"
+)
+
+func TestParseGoogleAPIError(t *testing.T) {
+	gerr := &googleapi.Error{
+		Code:    http.StatusBadRequest,
+		Message: "bad service",
+		Errors:  []googleapi.ErrorItem{{Reason: "invalid", Message: "spec.template is invalid"}},
+	}
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+	}{
+		{"nil", nil, 0},
+		{"not an api error", errors.New("boom"), 0},
+		{"api error", gerr, http.StatusBadRequest},
+		{"wrapped api error", fmt.Errorf("failed: %w", gerr), http.StatusBadRequest},
+		{"wrapped CloudRunError", fmt.Errorf("%w: %w", ErrConcurrentModification, apiError(gerr)), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseGoogleAPIError(tt.err)
+			if tt.wantCode == 0 {
+				if got != nil {
+					t.Fatalf("ParseGoogleAPIError() = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("ParseGoogleAPIError() = nil")
+			}
+			if got.HTTPCode != tt.wantCode || got.Message != "bad service" {
+				t.Errorf("ParseGoogleAPIError() = {%d %q}, want {%d %q}", got.HTTPCode, got.Message, tt.wantCode, "bad service")
+			}
+			if len(got.Details) != 1 || got.Details[0] != "invalid: spec.template is invalid" {
+				t.Errorf("Details = %q", got.Details)
+			}
+		})
+	}
+}
+
+func TestCloudRunErrorIsTransient(t *testing.T) {
+	for code, want := range map[int]bool{
+		http.StatusTooManyRequests:     true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusInternalServerError: true,
+		http.StatusNotFound:            false,
+		http.StatusConflict:            false,
+	} {
+		if got := (&CloudRunError{HTTPCode: code}).IsTransient(); got != want {
+			t.Errorf("IsTransient() for %d = %v, want %v", code, got, want)
+		}
+	}
+	if (*CloudRunError)(nil).IsTransient() {
+		t.Error("IsTransient() of nil = true")
+	}
+}
+
+func TestReplaceServiceConflict(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"error":{"code":409,"message":"resourceVersion mismatch","status":"ABORTED"}}`)
+	}))
+	defer srv.Close()
+	c, err := run.NewService(context.Background(), option.WithEndpoint(srv.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = replaceService(context.Background(), c, "p", &run.Service{Metadata: &run.ObjectMeta{Name: "app"}}, DeployOptions{})
+	if !errors.Is(err, ErrConcurrentModification) {
+		t.Errorf("replaceService() = %v, want ErrConcurrentModification", err)
+	}
+	var cre *CloudRunError
+	if !errors.As(err, &cre) || cre.HTTPCode != http.StatusConflict {
+		t.Errorf("replaceService() = %v, want a *CloudRunError with code 409", err)
+	}
+}
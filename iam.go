@@ -0,0 +1,181 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/run/v1"
+)
+
+// IAM policies are managed through the non-regional API endpoint, hence gc
+// in the functions below is a client created with run.NewService and no
+// endpoint override.
+
+// iamPolicyVersion is the policy version that supports conditional
+// bindings. Requesting a lower version would return conditional bindings
+// in a lossy format that must not be written back.
+const iamPolicyVersion = 3
+
+func serviceResourceName(project, region, name string) string {
+	return fmt.Sprintf("projects/%s/locations/%s/services/%s", project, region, name)
+}
+
+// GetIAMPolicy returns the IAM policy of the service, including the etag
+// to pass back when setting an updated policy.
+func GetIAMPolicy(ctx context.Context, gc *run.APIService, project, region, name string) (*run.Policy, error) {
+	p, err := gc.Projects.Locations.Services.GetIamPolicy(serviceResourceName(project, region, name)).
+		OptionsRequestedPolicyVersion(iamPolicyVersion).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get iam policy: %w", apiError(err))
+	}
+	return p, nil
+}
+
+// AddIAMBinding grants role to member on the service, keeping the rest of
+// the policy intact. It's a no-op if the member already has the role.
+func AddIAMBinding(ctx context.Context, gc *run.APIService, project, region, name, member, role string) error {
+	return updateIAMPolicy(ctx, gc, project, region, name, func(p *run.Policy) (bool, error) {
+		for _, b := range p.Bindings {
+			if b.Role != role || b.Condition != nil {
+				continue
+			}
+			for _, m := range b.Members {
+				if m == member {
+					return false, nil
+				}
+			}
+			b.Members = append(b.Members, member)
+			return true, nil
+		}
+		p.Bindings = append(p.Bindings, &run.Binding{Role: role, Members: []string{member}})
+		return true, nil
+	})
+}
+
+// updateIAMPolicy applies mutate to the current IAM policy of the service
+// and writes it back if it reports a change. The etag of the policy makes
+// the write fail if the policy changed in the meantime, in which case the
+// whole read-modify-write is retried a few times.
+func updateIAMPolicy(ctx context.Context, gc *run.APIService, project, region, name string, mutate func(*run.Policy) (changed bool, err error)) error {
+	const attempts = 3
+	backoff := time.Millisecond * 500
+	for i := 1; ; i++ {
+		p, err := GetIAMPolicy(ctx, gc, project, region, name)
+		if err != nil {
+			return err
+		}
+		changed, err := mutate(p)
+		if err != nil || !changed {
+			return err
+		}
+		p.Version = iamPolicyVersion
+		_, err = gc.Projects.Locations.Services.SetIamPolicy(serviceResourceName(project, region, name),
+			&run.SetIamPolicyRequest{Policy: p}).Context(ctx).Do()
+		if err == nil {
+			return nil
+		}
+		if v := ParseGoogleAPIError(err); v == nil || v.HTTPCode != http.StatusConflict || i == attempts {
+			return fmt.Errorf("failed to set iam policy: %w", apiError(err))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// RemoveIAMBinding revokes role from member on the service, dropping the
+// binding altogether if it has no members left. It returns
+// ErrBindingNotFound if the member didn't have the role, which callers
+// tearing things down can safely ignore.
+func RemoveIAMBinding(ctx context.Context, gc *run.APIService, project, region, name, member, role string) error {
+	return updateIAMPolicy(ctx, gc, project, region, name, func(p *run.Policy) (bool, error) {
+		for i, b := range p.Bindings {
+			if b.Role != role || b.Condition != nil {
+				continue
+			}
+			for j, m := range b.Members {
+				if m != member {
+					continue
+				}
+				b.Members = append(b.Members[:j], b.Members[j+1:]...)
+				if len(b.Members) == 0 {
+					p.Bindings = append(p.Bindings[:i], p.Bindings[i+1:]...)
+				}
+				return true, nil
+			}
+		}
+		return false, fmt.Errorf("%w: %s does not have %s", ErrBindingNotFound, member, role)
+	})
+}
+
+// MakeServicePrivate removes allUsers and allAuthenticatedUsers from every
+// binding in the IAM policy of the service, so that only explicitly granted
+// principals can invoke it.
+func MakeServicePrivate(ctx context.Context, gc *run.APIService, project, region, name string) error {
+	return updateIAMPolicy(ctx, gc, project, region, name, func(p *run.Policy) (bool, error) {
+		changed := false
+		bindings := p.Bindings[:0]
+		for _, b := range p.Bindings {
+			members := b.Members[:0]
+			for _, m := range b.Members {
+				if m == "allUsers" || m == "allAuthenticatedUsers" {
+					changed = true
+					continue
+				}
+				members = append(members, m)
+			}
+			b.Members = members
+			if len(b.Members) > 0 {
+				bindings = append(bindings, b)
+			}
+		}
+		p.Bindings = bindings
+		return changed, nil
+	})
+}
+
+// MakePublic lets anyone on the internet invoke the service, by allowing
+// all ingress and granting roles/run.invoker to allUsers. Ingress is set
+// through the regional client c, since gc can only manage IAM. The error
+// tells which of the two steps failed.
+func MakePublic(ctx context.Context, c, gc *run.APIService, project, region, name string) error {
+	svc, err := getService(c, region, project, name)
+	if err != nil {
+		return fmt.Errorf("failed to set ingress: failed to get service: %w", err)
+	}
+	// services without the annotation already allow all ingress.
+	if v, ok := svc.Metadata.Annotations["run.googleapis.com/ingress"]; ok && v != "all" {
+		if err := SetIngressMode(svc, "all"); err != nil {
+			return fmt.Errorf("failed to set ingress: %w", err)
+		}
+		if _, err := replaceService(ctx, c, project, svc, DeployOptions{}); err != nil {
+			return fmt.Errorf("failed to set ingress: %w", err)
+		}
+		if err := waitForReady(ctx, c, region, project, name, "Ready", DefaultWaitOptions()); err != nil {
+			return fmt.Errorf("failed to set ingress: %w", err)
+		}
+	}
+	if err := AddIAMBinding(ctx, gc, project, region, name, "allUsers", "roles/run.invoker"); err != nil {
+		return fmt.Errorf("failed to grant public access: %w", err)
+	}
+	return nil
+}
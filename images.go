@@ -0,0 +1,234 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/run/v1"
+)
+
+// CompareRevisionImages reports whether revA and revB run the same
+// container image, along with the images as configured on each of them.
+//
+// Images are compared by digest, so a tag and a digest reference to the
+// same image are equal. The digest Cloud Run resolved at deployment is used
+// where available, otherwise tags are resolved at the registry, which
+// might have moved them since.
+func CompareRevisionImages(ctx context.Context, c *run.APIService, region, project, revA, revB string) (bool, string, string, error) {
+	var images, digests [2]string
+	for i, name := range []string{revA, revB} {
+		rev, err := GetRevision(c, region, project, name)
+		if err != nil {
+			return false, "", "", err
+		}
+		if rev.Spec == nil || len(rev.Spec.Containers) == 0 {
+			return false, "", "", fmt.Errorf("revision %q has no containers", name)
+		}
+		images[i] = rev.Spec.Containers[0].Image
+		d, err := revisionImageDigest(ctx, rev)
+		if err != nil {
+			return false, "", "", fmt.Errorf("failed to resolve image of revision %q: %w", name, err)
+		}
+		digests[i] = d
+	}
+	return digests[0] == digests[1], images[0], images[1], nil
+}
+
+// revisionImageDigest returns the digest of the first container image of
+// rev, such as "sha256:…".
+func revisionImageDigest(ctx context.Context, rev *run.Revision) (string, error) {
+	if rev.Status != nil && rev.Status.ImageDigest != "" {
+		if _, d, ok := strings.Cut(rev.Status.ImageDigest, "@"); ok {
+			return d, nil
+		}
+	}
+	return resolveImageDigest(ctx, rev.Spec.Containers[0].Image)
+}
+
+// resolveImageDigest looks up the digest of image at its registry using
+// the Docker Registry HTTP API. Google registries are queried with the
+// application default credentials, others anonymously, getting a token if
+// the registry asks for one, as Docker Hub does even for public images.
+func resolveImageDigest(ctx context.Context, image string) (string, error) {
+	host, repo, ref := parseImageRef(image)
+	if strings.HasPrefix(ref, "sha256:") {
+		return ref, nil
+	}
+	hc := http.DefaultClient
+	if host == "gcr.io" || strings.HasSuffix(host, ".gcr.io") || strings.HasSuffix(host, "-docker.pkg.dev") {
+		var err error
+		hc, err = google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform")
+		if err != nil {
+			return "", fmt.Errorf("failed to get registry credentials: %w", err)
+		}
+	}
+
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, repo, ref)
+	resp, err := headManifest(ctx, hc, manifestURL, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := registryToken(ctx, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return "", fmt.Errorf("failed to authenticate to registry %s for image %q: %w", host, image, err)
+		}
+		if resp, err = headManifest(ctx, hc, manifestURL, token); err != nil {
+			return "", err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code=%d from registry for image %q", resp.StatusCode, image)
+	}
+	d := resp.Header.Get("Docker-Content-Digest")
+	if d == "" {
+		return "", fmt.Errorf("registry returned no digest for image %q", image)
+	}
+	return d, nil
+}
+
+// headManifest sends a HEAD request for an image manifest, with token as
+// the bearer token if it's set.
+func headManifest(ctx context.Context, hc *http.Client, url, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join([]string{
+		"application/vnd.oci.image.index.v1+json",
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+	}, ","))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query registry: %w", err)
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// registryToken gets an anonymous pull token as asked for by the
+// WWW-Authenticate header of a registry, such as Docker Hub's
+//
+//	Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"
+func registryToken(ctx context.Context, challenge string) (string, error) {
+	realm, params, err := parseBearerChallenge(challenge)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(realm)
+	if err != nil || u.Scheme != "https" {
+		return "", fmt.Errorf("invalid token realm %q", realm)
+	}
+	q := u.Query()
+	for k, v := range params {
+		q.Set(k, v)
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code=%d from %s", resp.StatusCode, u.Host)
+	}
+	var v struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return "", fmt.Errorf("failed to decode token: %w", err)
+	}
+	if v.Token == "" {
+		v.Token = v.AccessToken
+	}
+	if v.Token == "" {
+		return "", fmt.Errorf("no token returned by %s", u.Host)
+	}
+	return v.Token, nil
+}
+
+// parseBearerChallenge parses a WWW-Authenticate header with the Bearer
+// scheme into its realm and its other parameters, such as service and
+// scope. Other schemes, such as Basic for registries needing a login, are
+// not supported.
+func parseBearerChallenge(challenge string) (string, map[string]string, error) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", nil, fmt.Errorf("unsupported registry authentication %q, only anonymous bearer tokens are supported", scheme)
+	}
+	params := make(map[string]string)
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		k, v, ok := strings.Cut(rest, "=")
+		if !ok {
+			return "", nil, fmt.Errorf("invalid authentication challenge %q", challenge)
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		if strings.HasPrefix(v, `"`) {
+			end := strings.Index(v[1:], `"`)
+			if end < 0 {
+				return "", nil, fmt.Errorf("invalid authentication challenge %q", challenge)
+			}
+			params[k], rest = v[1:end+1], v[end+2:]
+		} else {
+			params[k], rest, _ = strings.Cut(v, ",")
+		}
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	realm := params["realm"]
+	if realm == "" {
+		return "", nil, fmt.Errorf("no realm in authentication challenge %q", challenge)
+	}
+	delete(params, "realm")
+	return realm, params, nil
+}
+
+// parseImageRef splits an image reference into its registry host,
+// repository and tag or digest, filling in the defaults of the docker CLI.
+func parseImageRef(image string) (host, repo, ref string) {
+	host, repo = "registry-1.docker.io", image
+	if i := strings.Index(image, "/"); i >= 0 {
+		if h := image[:i]; strings.ContainsAny(h, ".:") || h == "localhost" {
+			host, repo = h, image[i+1:]
+		}
+	}
+	if host == "registry-1.docker.io" && !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+	if r, d, ok := strings.Cut(repo, "@"); ok {
+		return host, r, d
+	}
+	ref = "latest"
+	if i := strings.LastIndex(repo, ":"); i >= 0 && !strings.Contains(repo[i:], "/") {
+		repo, ref = repo[:i], repo[i+1:]
+	}
+	return host, repo, ref
+}
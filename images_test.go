@@ -0,0 +1,117 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		image, host, repo, ref string
+	}{
+		{"nginx", "registry-1.docker.io", "library/nginx", "latest"},
+		{"nginx:1.25", "registry-1.docker.io", "library/nginx", "1.25"},
+		{"bitnami/redis:7", "registry-1.docker.io", "bitnami/redis", "7"},
+		{"gcr.io/p/app", "gcr.io", "p/app", "latest"},
+		{"us-docker.pkg.dev/p/r/app:v1", "us-docker.pkg.dev", "p/r/app", "v1"},
+		{"localhost:5000/app:v1", "localhost:5000", "app", "v1"},
+		{"gcr.io/p/app@sha256:abc", "gcr.io", "p/app", "sha256:abc"},
+	}
+	for _, tt := range tests {
+		host, repo, ref := parseImageRef(tt.image)
+		if host != tt.host || repo != tt.repo || ref != tt.ref {
+			t.Errorf("parseImageRef(%q) = %q, %q, %q, want %q, %q, %q", tt.image, host, repo, ref, tt.host, tt.repo, tt.ref)
+		}
+	}
+}
+
+func TestParseBearerChallenge(t *testing.T) {
+	tests := []struct {
+		challenge string
+		realm     string
+		params    map[string]string
+		wantErr   bool
+	}{
+		{
+			challenge: `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"`,
+			realm:     "https://auth.docker.io/token",
+			params:    map[string]string{"service": "registry.docker.io", "scope": "repository:library/nginx:pull"},
+		},
+		{
+			challenge: `bearer realm="https://ghcr.io/token", scope="repository:o/app:pull"`,
+			realm:     "https://ghcr.io/token",
+			params:    map[string]string{"scope": "repository:o/app:pull"},
+		},
+		{challenge: `Basic realm="registry"`, wantErr: true},
+		{challenge: `Bearer service="x"`, wantErr: true},
+		{challenge: `Bearer realm="https://unterminated`, wantErr: true},
+		{challenge: ``, wantErr: true},
+	}
+	for _, tt := range tests {
+		realm, params, err := parseBearerChallenge(tt.challenge)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseBearerChallenge(%q) succeeded, want error", tt.challenge)
+			}
+			continue
+		}
+		if err != nil || realm != tt.realm || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("parseBearerChallenge(%q) = %q, %v, %v, want %q, %v", tt.challenge, realm, params, err, tt.realm, tt.params)
+		}
+	}
+}
+
+func TestResolveImageDigestToken(t *testing.T) {
+	const digest = "sha256:0123"
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if r.URL.Query().Get("scope") != "repository:team/app:pull" {
+				http.Error(w, "bad scope", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"token":"t0k"}`)
+		case r.URL.Path == "/v2/team/app/manifests/v1":
+			if r.Header.Get("Authorization") != "Bearer t0k" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:team/app:pull"`, srv.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", digest)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(c *http.Client) { http.DefaultClient = c }(http.DefaultClient)
+	http.DefaultClient = srv.Client()
+
+	image := strings.TrimPrefix(srv.URL, "https://") + "/team/app:v1"
+	got, err := resolveImageDigest(context.Background(), image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != digest {
+		t.Errorf("resolveImageDigest() = %q, want %q", got, digest)
+	}
+}
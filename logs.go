@@ -0,0 +1,205 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	logging "google.golang.org/api/logging/v2"
+)
+
+// revisionLogFilter is the Cloud Logging filter of the entries written by
+// a revision since the given time.
+func revisionLogFilter(revisionName string, since time.Time) string {
+	return fmt.Sprintf(`resource.type="cloud_run_revision" AND resource.labels.revision_name=%q AND timestamp>=%q`,
+		revisionName, since.UTC().Format(time.RFC3339Nano))
+}
+
+// GetRevisionLogs returns up to maxEntries of the most recent log entries
+// written by the revision over the last since, oldest first.
+func GetRevisionLogs(ctx context.Context, lc *logging.Service, project, revisionName string, since time.Duration, maxEntries int) ([]*logging.LogEntry, error) {
+	if maxEntries <= 0 {
+		return nil, fmt.Errorf("max entries must be positive, got %d", maxEntries)
+	}
+	req := &logging.ListLogEntriesRequest{
+		ResourceNames: []string{"projects/" + project},
+		Filter:        revisionLogFilter(revisionName, time.Now().Add(-since)),
+		OrderBy:       "timestamp desc",
+	}
+	var out []*logging.LogEntry
+	for len(out) < maxEntries {
+		req.PageSize = int64(maxEntries - len(out))
+		if req.PageSize > 1000 {
+			req.PageSize = 1000
+		}
+		resp, err := lc.Entries.List(req).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list log entries: %w", apiError(err))
+		}
+		out = append(out, resp.Entries...)
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	if len(out) > maxEntries {
+		out = out[:maxEntries]
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
+
+// logStreamInterval is how often StreamRevisionLogs polls for new entries.
+// The Logging API allows 60 list calls per minute per project.
+const logStreamInterval = time.Second * 5
+
+// StreamRevisionLogs sends the log entries the revision writes from now on
+// to the returned channel, oldest first, until ctx is done and the channel
+// is closed. Entries are polled for, so they arrive a few seconds late;
+// entries ingested after newer ones were already sent are skipped. Failed
+// polls are logged to the logger of ctx and retried.
+func StreamRevisionLogs(ctx context.Context, lc *logging.Service, project, revisionName string) (<-chan *logging.LogEntry, error) {
+	if revisionName == "" {
+		return nil, fmt.Errorf("revision name is not set")
+	}
+	ch := make(chan *logging.LogEntry)
+	go func() {
+		defer close(ch)
+		since := time.Now()
+		// entries at the since timestamp that were already sent, as the
+		// next poll includes them again.
+		sent := make(map[string]bool)
+		t := time.NewTicker(logStreamInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			req := &logging.ListLogEntriesRequest{
+				ResourceNames: []string{"projects/" + project},
+				Filter:        revisionLogFilter(revisionName, since),
+				OrderBy:       "timestamp asc",
+				PageSize:      1000,
+			}
+			for {
+				resp, err := lc.Entries.List(req).Context(ctx).Do()
+				if err != nil {
+					if ctx.Err() == nil {
+						loggerFrom(ctx).Warn("failed to list log entries",
+							"revision", revisionName, "error", apiError(err))
+					}
+					break
+				}
+				for _, e := range resp.Entries {
+					if sent[e.InsertId] {
+						continue
+					}
+					if ts, err := time.Parse(time.RFC3339Nano, e.Timestamp); err == nil && ts.After(since) {
+						since = ts
+						sent = make(map[string]bool)
+					}
+					sent[e.InsertId] = true
+					select {
+					case <-ctx.Done():
+						return
+					case ch <- e:
+					}
+				}
+				if resp.NextPageToken == "" {
+					break
+				}
+				req.PageToken = resp.NextPageToken
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// AuditEntry is an administrative operation on a service recorded in
+// Cloud Audit Logs.
+type AuditEntry struct {
+	// Principal is the email of the account that made the call.
+	Principal string
+	// Method is the API method called, such as
+	// google.cloud.run.v1.Services.ReplaceService.
+	Method    string
+	Timestamp time.Time
+	// Status is "OK" for successful calls, or the error otherwise.
+	Status string
+}
+
+// auditLogPayload is the part of the protoPayload of audit log entries
+// that's turned into an AuditEntry.
+type auditLogPayload struct {
+	MethodName         string `json:"methodName"`
+	AuthenticationInfo struct {
+		PrincipalEmail string `json:"principalEmail"`
+	} `json:"authenticationInfo"`
+	Status *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+// GetAuditLog returns the administrative operations on the service, such as
+// its creation, updates and deletion, since the given time, oldest first.
+func GetAuditLog(ctx context.Context, lc *logging.Service, project, region, serviceName string, since time.Time) ([]AuditEntry, error) {
+	filter := fmt.Sprintf(`logName="projects/%s/logs/cloudaudit.googleapis.com%%2Factivity" AND `+
+		`protoPayload.serviceName="run.googleapis.com" AND resource.labels.service_name=%q AND `+
+		`resource.labels.location=%q AND timestamp>=%q`,
+		project, serviceName, region, since.UTC().Format(time.RFC3339Nano))
+	req := &logging.ListLogEntriesRequest{
+		ResourceNames: []string{"projects/" + project},
+		Filter:        filter,
+		OrderBy:       "timestamp asc",
+		PageSize:      1000,
+	}
+	var out []AuditEntry
+	for {
+		resp, err := lc.Entries.List(req).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list audit log entries: %w", apiError(err))
+		}
+		for _, e := range resp.Entries {
+			var p auditLogPayload
+			if err := json.Unmarshal(e.ProtoPayload, &p); err != nil {
+				return nil, fmt.Errorf("failed to parse audit log entry %s: %w", e.InsertId, err)
+			}
+			ts, _ := time.Parse(time.RFC3339Nano, e.Timestamp)
+			status := "OK"
+			if p.Status != nil && p.Status.Code != 0 {
+				status = fmt.Sprintf("code=%d %s", p.Status.Code, p.Status.Message)
+			}
+			out = append(out, AuditEntry{
+				Principal: p.AuthenticationInfo.PrincipalEmail,
+				Method:    p.MethodName,
+				Timestamp: ts,
+				Status:    status,
+			})
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	return out, nil
+}
@@ -18,10 +18,10 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
-	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"google.golang.org/api/run/v1"
 )
@@ -56,7 +56,7 @@ func main() {
 	// check if Cloud Run service exists.
 	exists, err := serviceExists(c, region, project, name)
 	panicIfErr(err)
-	log.Printf("service exists?: %v", exists)
+	slog.Info("checked if service exists", "service", name, "exists", exists)
 
 	// deploying the first revision (v1) is quite easy.
 	// check out the YAML tab of your service and reconstruct it in code.
@@ -81,42 +81,34 @@ func main() {
 	}
 	_, err = c.Namespaces.Services.Create("namespaces/"+project, svc).Do()
 	panicIfErr(err)
-	log.Printf("service create call completed")
+	slog.Info("service create call completed", "service", name)
 	// at this point, the service might not be ready.
 	// to check if the Revision works correctly or not,
 	// see the status field on the Service object by querying it
 
 	// wait for revision to become ready
-	log.Printf("waiting for service to become ready")
+	slog.Info("waiting for service to become ready", "service", name)
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*120)
 	defer cancel()
 
-	err = waitForReady(ctx, c, region, project, name, "Ready")
+	err = waitForReady(ctx, c, region, project, name, "Ready", DefaultWaitOptions())
 	panicIfErr(err)
-	err = waitForReady(ctx, c, region, project, name, "RoutesReady")
+	err = waitForReady(ctx, c, region, project, name, "RoutesReady", DefaultWaitOptions())
 	panicIfErr(err)
-	log.Printf("service is ready and serving traffic!")
+	slog.Info("service is ready and serving traffic!", "service", name)
 
 	// give service public access via IAM bindings.
 	// we'll need to use the non-regional API endpoint with this.
 	gc, err := run.NewService(context.TODO())
 	panicIfErr(err)
-	_, err = gc.Projects.Locations.Services.SetIamPolicy(
-		fmt.Sprintf("projects/%s/locations/%s/services/%s", project, region, name),
-		&run.SetIamPolicyRequest{
-			Policy: &run.Policy{Bindings: []*run.Binding{{
-				Members: []string{"allUsers"},
-				Role:    "roles/run.invoker",
-			}}},
-		},
-	).Do()
+	err = MakePublic(ctx, c, gc, project, region, name)
 	panicIfErr(err)
 
-	// print the service URL by re-querying the service because the
-	// url becomes available on the object after the Create() call
-	svc, err = getService(c, region, project, name)
+	// print the service URL. it's not on the object returned by the
+	// Create() call, and becomes available on the service a bit later.
+	url, err := GetServiceURL(ctx, c, region, project, name)
 	panicIfErr(err)
-	log.Printf("service is deployed at: %s", svc.Status.Address.Url)
+	slog.Info("service is deployed", "service", name, "url", url)
 
 	// to deploy a new revision, we need a fresh Service object from
 	// the API. this way we can make use of the builtin optimistic concurrency
@@ -140,14 +132,14 @@ func main() {
 	}}
 	_, err = c.Namespaces.Services.ReplaceService(fmt.Sprintf("namespaces/%s/services/%s", project, name), svc).Do()
 	panicIfErr(err)
-	log.Printf("deployed an update, might not be ready")
+	slog.Info("deployed an update, might not be ready", "service", name, "revision", name+"-v2")
 
 	// wait for the service to become ready and start serving the route changes
-	err = waitForReady(ctx, c, region, project, name, "Ready")
+	err = waitForReady(ctx, c, region, project, name, "Ready", DefaultWaitOptions())
 	panicIfErr(err)
-	err = waitForReady(ctx, c, region, project, name, "RoutesReady")
+	err = waitForReady(ctx, c, region, project, name, "RoutesReady", DefaultWaitOptions())
 	panicIfErr(err)
-	log.Printf("updated service is ready and serving with traffic split")
+	slog.Info("updated service is ready and serving with traffic split", "service", name)
 
 	// delete the service.
 	op, err := c.Namespaces.Services.Delete(fmt.Sprintf("namespaces/%s/services/%s", project, name)).Do()
@@ -157,7 +149,7 @@ func main() {
 	// and it will eventually disappear from the API (serviceExists will return false).
 	// Not implementing that here for brevity.
 	_ = op
-	log.Printf("deleted service")
+	slog.Info("deleted service", "service", name)
 }
 
 func serviceExists(c *run.APIService, region, project, name string) (bool, error) {
@@ -166,44 +158,19 @@ func serviceExists(c *run.APIService, region, project, name string) (bool, error
 		return true, nil
 	}
 	// not all errors indicate service does not exist, look for 404 status code
-	v, ok := err.(*googleapi.Error)
-	if !ok {
+	v := ParseGoogleAPIError(err)
+	if v == nil {
 		return false, fmt.Errorf("failed to query service: %w", err)
 	}
-	if v.Code == http.StatusNotFound {
+	if v.HTTPCode == http.StatusNotFound {
 		return false, nil
 	}
-	return false, fmt.Errorf("unexpected status code=%d from get service call: %w", v.Code, err)
+	return false, fmt.Errorf("unexpected status code=%d from get service call: %w", v.HTTPCode, v)
 }
 
 func getService(c *run.APIService, region, project, name string) (*run.Service, error) {
-	return c.Namespaces.Services.Get(fmt.Sprintf("namespaces/%s/services/%s", project, name)).Do()
-}
-
-func waitForReady(ctx context.Context, c *run.APIService, region, project, name, condition string) error {
-	t := time.NewTicker(time.Second * 5)
-	defer t.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-t.C:
-			svc, err := getService(c, region, project, name)
-			if err != nil {
-				return fmt.Errorf("failed to query service for readiness: %w", err)
-			}
-			for _, c := range svc.Status.Conditions {
-				if c.Type == condition {
-					if c.Status == "True" {
-						return nil
-					} else if c.Status == "False" {
-						return fmt.Errorf("service could not become %q (status:%s) (reason:%s) %s",
-							condition, c.Status, c.Reason, c.Message)
-					}
-				}
-			}
-		}
-	}
+	svc, err := c.Namespaces.Services.Get(fmt.Sprintf("namespaces/%s/services/%s", project, name)).Do()
+	return svc, apiError(err)
 }
 
 func client(region string) (*run.APIService, error) {
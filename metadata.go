@@ -0,0 +1,223 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/run/v1"
+)
+
+var (
+	// https://kubernetes.io/docs/concepts/overview/working-with-objects/labels/#syntax-and-character-set
+	qualifiedNameRe = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$`)
+	dnsSubdomainRe  = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// validKey reports whether k is a valid label or annotation key, that is a
+// name of up to 63 characters with an optional DNS subdomain prefix.
+func validKey(k string) bool {
+	name := k
+	if i := strings.LastIndex(k, "/"); i >= 0 {
+		prefix := k[:i]
+		if len(prefix) > 253 || !dnsSubdomainRe.MatchString(prefix) {
+			return false
+		}
+		name = k[i+1:]
+	}
+	return name != "" && len(name) <= 63 && qualifiedNameRe.MatchString(name)
+}
+
+// SetLabels merges labels into the labels of the service. Existing labels
+// not mentioned in labels are kept.
+func SetLabels(svc *run.Service, labels map[string]string) error {
+	var problems []string
+	for k, v := range labels {
+		if !validKey(k) {
+			problems = append(problems, fmt.Sprintf("invalid label key %q", k))
+		}
+		if len(v) > 63 || !qualifiedNameRe.MatchString(v) {
+			problems = append(problems, fmt.Sprintf("invalid value %q for label %q", v, k))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return &ValidationError{Problems: problems}
+	}
+	m := serviceMetadata(svc)
+	if m.Labels == nil {
+		m.Labels = make(map[string]string, len(labels))
+	}
+	for k, v := range labels {
+		m.Labels[k] = v
+	}
+	return nil
+}
+
+// SetAnnotations merges annotations into the annotations of the service.
+// Existing annotations not mentioned in annotations are kept.
+func SetAnnotations(svc *run.Service, annotations map[string]string) error {
+	if err := validateAnnotationKeys(annotations); err != nil {
+		return err
+	}
+	m := serviceMetadata(svc)
+	if m.Annotations == nil {
+		m.Annotations = make(map[string]string, len(annotations))
+	}
+	for k, v := range annotations {
+		m.Annotations[k] = v
+	}
+	return nil
+}
+
+// AnnotateRevision merges annotations into the annotations of the revision
+// template of svc, so that the revision created by deploying it carries
+// them, such as the git commit it was built from.
+//
+// The API offers no way to modify a revision once it's created, so
+// annotations have to be set this way before deploying it.
+func AnnotateRevision(svc *run.Service, annotations map[string]string) error {
+	if err := validateAnnotationKeys(annotations); err != nil {
+		return err
+	}
+	a := templateAnnotations(svc)
+	for k, v := range annotations {
+		a[k] = v
+	}
+	return nil
+}
+
+func validateAnnotationKeys(annotations map[string]string) error {
+	var problems []string
+	for k := range annotations {
+		if !validKey(k) {
+			problems = append(problems, fmt.Sprintf("invalid annotation key %q", k))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func serviceMetadata(svc *run.Service) *run.ObjectMeta {
+	if svc.Metadata == nil {
+		svc.Metadata = &run.ObjectMeta{}
+	}
+	return svc.Metadata
+}
+
+// ingressModes are the valid values of the run.googleapis.com/ingress
+// annotation.
+var ingressModes = []string{"all", "internal", "internal-and-cloud-load-balancing"}
+
+// SetIngressMode restricts where the service can be reached from. Unlike
+// most settings, ingress is an annotation on the service itself rather than
+// on the revision template.
+func SetIngressMode(svc *run.Service, mode string) error {
+	valid := false
+	for _, m := range ingressModes {
+		if m == mode {
+			valid = true
+		}
+	}
+	if !valid {
+		return fmt.Errorf("%w %q (valid values: %s)", ErrInvalidIngressMode, mode, strings.Join(ingressModes, ", "))
+	}
+	m := serviceMetadata(svc)
+	if m.Annotations == nil {
+		m.Annotations = make(map[string]string)
+	}
+	m.Annotations["run.googleapis.com/ingress"] = mode
+	return nil
+}
+
+// SetCustomAudiences sets the audiences, besides the service URL, that ID
+// tokens sent to the service may be issued for. Like ingress, this is an
+// annotation on the service itself. Passing no audiences removes the
+// setting.
+func SetCustomAudiences(svc *run.Service, audiences []string) error {
+	seen := make(map[string]bool, len(audiences))
+	var out []string
+	for _, a := range audiences {
+		if u, err := url.Parse(a); a == "" || err != nil || u.Scheme == "" {
+			return fmt.Errorf("invalid audience %q, must be an absolute uri", a)
+		}
+		if !seen[a] {
+			seen[a] = true
+			out = append(out, a)
+		}
+	}
+	m := serviceMetadata(svc)
+	if len(out) == 0 {
+		delete(m.Annotations, "run.googleapis.com/custom-audiences")
+		return nil
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	if m.Annotations == nil {
+		m.Annotations = make(map[string]string)
+	}
+	m.Annotations["run.googleapis.com/custom-audiences"] = string(b)
+	return nil
+}
+
+// Annotations written by SetBuildInfo, to look revisions up by with
+// FindRevisionByAnnotation.
+const (
+	BuildGitCommitAnnotation = "build.cloud-run-deploy/git-commit"
+	BuildGitBranchAnnotation = "build.cloud-run-deploy/git-branch"
+	BuildIDAnnotation        = "build.cloud-run-deploy/build-id"
+	BuildTimestampAnnotation = "build.cloud-run-deploy/build-timestamp"
+	BuiltByAnnotation        = "build.cloud-run-deploy/built-by"
+)
+
+// BuildInfo describes the build that produced the image of a revision.
+type BuildInfo struct {
+	GitCommit      string
+	GitBranch      string
+	BuildID        string
+	BuildTimestamp string
+	BuiltBy        string
+}
+
+// SetBuildInfo records info in the annotations of the revision template of
+// svc, so the revision created by deploying it can be traced back to its
+// build. Fields left empty remove the annotation, so that what's recorded
+// always comes from a single build.
+func SetBuildInfo(svc *run.Service, info BuildInfo) {
+	a := templateAnnotations(svc)
+	for k, v := range map[string]string{
+		BuildGitCommitAnnotation: info.GitCommit,
+		BuildGitBranchAnnotation: info.GitBranch,
+		BuildIDAnnotation:        info.BuildID,
+		BuildTimestampAnnotation: info.BuildTimestamp,
+		BuiltByAnnotation:        info.BuiltBy,
+	} {
+		if v == "" {
+			delete(a, k)
+		} else {
+			a[k] = v
+		}
+	}
+}
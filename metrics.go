@@ -0,0 +1,196 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	monitoring "google.golang.org/api/monitoring/v3"
+)
+
+// MetricsClient reports how a revision is doing, so deployments can be
+// gated on it. CloudMonitoringMetrics implements it on top of Cloud
+// Monitoring.
+type MetricsClient interface {
+	// ErrorRate returns the fraction (0 to 1) of the requests served by
+	// the revision over the last window that got a 5xx response.
+	ErrorRate(ctx context.Context, project, region, service, revision string, window time.Duration) (float64, error)
+}
+
+// CloudMonitoringMetrics is a MetricsClient backed by the Cloud Monitoring
+// API.
+type CloudMonitoringMetrics struct {
+	Service *monitoring.Service
+}
+
+func (m CloudMonitoringMetrics) ErrorRate(ctx context.Context, project, region, service, revision string, window time.Duration) (float64, error) {
+	counts, err := requestCountsByClass(ctx, m.Service, project, revisionFilter(region, service, revision), window)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	return float64(counts["5xx"]) / float64(total), nil
+}
+
+// ServiceMetrics summarizes the traffic served by a service over a window.
+type ServiceMetrics struct {
+	RequestCount int64
+	P50Latency   time.Duration
+	P99Latency   time.Duration
+	// ErrorRate is the fraction (0 to 1) of requests that got a 5xx
+	// response.
+	ErrorRate float64
+}
+
+// GetServiceMetrics returns the request count, latency percentiles and
+// error rate of the service over the last window, across all revisions.
+func GetServiceMetrics(ctx context.Context, mc *monitoring.Service, project, region, name string, window time.Duration) (*ServiceMetrics, error) {
+	filter := revisionFilter(region, name, "")
+	counts, err := requestCountsByClass(ctx, mc, project, filter, window)
+	if err != nil {
+		return nil, err
+	}
+	var out ServiceMetrics
+	for _, n := range counts {
+		out.RequestCount += n
+	}
+	if out.RequestCount > 0 {
+		out.ErrorRate = float64(counts["5xx"]) / float64(out.RequestCount)
+	}
+	if out.P50Latency, err = latencyPercentile(ctx, mc, project, filter, window, "REDUCE_PERCENTILE_50"); err != nil {
+		return nil, err
+	}
+	if out.P99Latency, err = latencyPercentile(ctx, mc, project, filter, window, "REDUCE_PERCENTILE_99"); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetRevisionCPUUtilization returns the mean CPU utilization (0 to 1) of
+// the containers of the revision over the last window, across instances.
+// It's zero if the revision had no instances.
+func GetRevisionCPUUtilization(ctx context.Context, mc *monitoring.Service, project, region, revisionName string, window time.Duration) (float64, error) {
+	end := time.Now()
+	filter := fmt.Sprintf(`metric.type="run.googleapis.com/container/cpu/utilizations" AND `+
+		`resource.type="cloud_run_revision" AND resource.labels.location=%q AND resource.labels.revision_name=%q`,
+		region, revisionName)
+	resp, err := mc.Projects.TimeSeries.List("projects/" + project).
+		Filter(filter).
+		IntervalStartTime(end.Add(-window).Format(time.RFC3339)).
+		IntervalEndTime(end.Format(time.RFC3339)).
+		AggregationAlignmentPeriod(alignmentPeriod(window)).
+		AggregationPerSeriesAligner("ALIGN_MEAN").
+		AggregationCrossSeriesReducer("REDUCE_MEAN").
+		Context(ctx).Do()
+	if err != nil {
+		return 0, fmt.Errorf("failed to query cpu utilization: %w", apiError(err))
+	}
+	for _, ts := range resp.TimeSeries {
+		for _, p := range ts.Points {
+			if p.Value != nil && p.Value.DoubleValue != nil {
+				return *p.Value.DoubleValue, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+// revisionFilter matches the time series of a revision, or of the whole
+// service if revision is empty.
+func revisionFilter(region, service, revision string) string {
+	f := fmt.Sprintf(`resource.type="cloud_run_revision" AND resource.labels.location=%q AND resource.labels.service_name=%q`,
+		region, service)
+	if revision != "" {
+		f += fmt.Sprintf(` AND resource.labels.revision_name=%q`, revision)
+	}
+	return f
+}
+
+// requestCountsByClass returns the number of requests matching filter
+// over the last window, keyed by response code class ("2xx", "5xx", ...).
+func requestCountsByClass(ctx context.Context, ms *monitoring.Service, project, filter string, window time.Duration) (map[string]int64, error) {
+	end := time.Now()
+	out := make(map[string]int64)
+	err := ms.Projects.TimeSeries.List("projects/"+project).
+		Filter(`metric.type="run.googleapis.com/request_count" AND `+filter).
+		IntervalStartTime(end.Add(-window).Format(time.RFC3339)).
+		IntervalEndTime(end.Format(time.RFC3339)).
+		AggregationAlignmentPeriod(alignmentPeriod(window)).
+		AggregationPerSeriesAligner("ALIGN_SUM").
+		AggregationCrossSeriesReducer("REDUCE_SUM").
+		AggregationGroupByFields("metric.labels.response_code_class").
+		Pages(ctx, func(resp *monitoring.ListTimeSeriesResponse) error {
+			for _, ts := range resp.TimeSeries {
+				class := ""
+				if ts.Metric != nil {
+					class = ts.Metric.Labels["response_code_class"]
+				}
+				for _, p := range ts.Points {
+					if p.Value != nil && p.Value.Int64Value != nil {
+						out[class] += *p.Value.Int64Value
+					}
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query request counts: %w", apiError(err))
+	}
+	return out, nil
+}
+
+// alignmentPeriod aggregates a whole window into a single point. Cloud
+// Monitoring does not accept periods shorter than a minute.
+func alignmentPeriod(window time.Duration) string {
+	if window < time.Minute {
+		window = time.Minute
+	}
+	return fmt.Sprintf("%ds", int64(window/time.Second))
+}
+
+// latencyPercentile returns a percentile of the request latencies matching
+// filter over the last window, where reducer is one of the
+// REDUCE_PERCENTILE_* reducers. It's zero if no requests were served.
+func latencyPercentile(ctx context.Context, ms *monitoring.Service, project, filter string, window time.Duration, reducer string) (time.Duration, error) {
+	end := time.Now()
+	resp, err := ms.Projects.TimeSeries.List("projects/" + project).
+		Filter(`metric.type="run.googleapis.com/request_latencies" AND ` + filter).
+		IntervalStartTime(end.Add(-window).Format(time.RFC3339)).
+		IntervalEndTime(end.Format(time.RFC3339)).
+		AggregationAlignmentPeriod(alignmentPeriod(window)).
+		AggregationPerSeriesAligner("ALIGN_DELTA").
+		AggregationCrossSeriesReducer(reducer).
+		Context(ctx).Do()
+	if err != nil {
+		return 0, fmt.Errorf("failed to query request latencies: %w", apiError(err))
+	}
+	// a single series with a single point is expected, take the latest.
+	for _, ts := range resp.TimeSeries {
+		for _, p := range ts.Points {
+			if p.Value != nil && p.Value.DoubleValue != nil {
+				return time.Duration(*p.Value.DoubleValue * float64(time.Millisecond)), nil
+			}
+		}
+	}
+	return 0, nil
+}
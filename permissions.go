@@ -0,0 +1,88 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// RequiredOp is an operation on a service that CheckPermissions can check
+// the caller is allowed to perform.
+type RequiredOp int
+
+const (
+	RequireCreate RequiredOp = iota + 1
+	RequireUpdate
+	RequireDelete
+	RequireSetIAMPolicy
+	// RequireActAs is needed to deploy revisions running as a service
+	// account.
+	RequireActAs
+)
+
+// requiredPermissions are the IAM permissions each operation needs.
+var requiredPermissions = map[RequiredOp][]string{
+	RequireCreate:       {"run.services.create", "run.services.get"},
+	RequireUpdate:       {"run.services.update", "run.services.get"},
+	RequireDelete:       {"run.services.delete"},
+	RequireSetIAMPolicy: {"run.services.getIamPolicy", "run.services.setIamPolicy"},
+	RequireActAs:        {"iam.serviceAccounts.actAs"},
+}
+
+// CheckPermissions returns the permissions the caller lacks, sorted, to
+// perform ops on the service, so they can be granted before a deployment
+// fails halfway through with a 403.
+//
+// Permissions are tested on the project, so permissions granted only on
+// the service itself (or on a service account, for RequireActAs) are
+// reported missing even though they'd be enough.
+func CheckPermissions(ctx context.Context, crm *cloudresourcemanager.Service, project, region, name string, ops []RequiredOp) ([]string, error) {
+	want := make(map[string]bool)
+	for _, op := range ops {
+		perms, ok := requiredPermissions[op]
+		if !ok {
+			return nil, fmt.Errorf("unknown operation %d", op)
+		}
+		for _, p := range perms {
+			want[p] = true
+		}
+	}
+	if len(want) == 0 {
+		return nil, nil
+	}
+	req := &cloudresourcemanager.TestIamPermissionsRequest{}
+	for p := range want {
+		req.Permissions = append(req.Permissions, p)
+	}
+	sort.Strings(req.Permissions)
+	resp, err := crm.Projects.TestIamPermissions(project, req).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to test permissions of service %q in %s: %w", name, region, apiError(err))
+	}
+	for _, p := range resp.Permissions {
+		delete(want, p)
+	}
+	var missing []string
+	for _, p := range req.Permissions {
+		if want[p] {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
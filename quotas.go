@@ -0,0 +1,113 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/run/v1"
+	serviceusage "google.golang.org/api/serviceusage/v1beta1"
+)
+
+const (
+	// writeRequestsQuota is the quota of Cloud Run Admin API write requests
+	// per minute and region. Deploying a service takes one write request.
+	writeRequestsQuota = "run.googleapis.com/write_requests 1/min/{project}/{region}"
+	// defaultMaxServicesPerRegion is the documented limit of services in a
+	// region. Service Usage doesn't report it as a quota.
+	defaultMaxServicesPerRegion = 1000
+)
+
+// CloudRunQuotas are the quota limits of Cloud Run in a project, and the
+// current usage they can be checked against.
+type CloudRunQuotas struct {
+	// MaxServicesPerRegion is how many services a region can have. There's
+	// no quota metric for it, so it's the documented limit, which may have
+	// been raised for the project on request.
+	MaxServicesPerRegion int64
+	// CurrentServicesPerRegion is how many services there are in each of
+	// the regions of the clients passed to GetCloudRunQuotas.
+	CurrentServicesPerRegion map[string]int64
+	// MaxConcurrentDeployments is how many deployments can be started in
+	// a region within a minute, which is the write request quota of the
+	// Admin API, as Cloud Run has no quota on deployments themselves. It's
+	// the limit of regions without one of their own, see Limit for those.
+	// Negative values are unlimited, 0 means the quota wasn't reported.
+	MaxConcurrentDeployments int64
+	// Limits are the effective limits by quota metric and unit separated
+	// by a space, such as "run.googleapis.com/write_requests
+	// 1/min/{project}/{region}", then by region, where "" is the limit of
+	// regions without a limit of their own.
+	Limits map[string]map[string]int64
+}
+
+// Limit returns the limit of the quota metric (with its unit, as in
+// Limits) in region, or false if there's no such quota. Negative limits
+// are unlimited.
+func (q *CloudRunQuotas) Limit(metric, region string) (int64, bool) {
+	byRegion, ok := q.Limits[metric]
+	if !ok {
+		return 0, false
+	}
+	if v, ok := byRegion[region]; ok {
+		return v, true
+	}
+	v, ok := byRegion[""]
+	return v, ok
+}
+
+// GetCloudRunQuotas returns the quota limits of Cloud Run in the project,
+// and the number of services in each of the regions, so that the headroom
+// for a batch of deployments can be checked before starting it. clients
+// maps each region to count services in to its regional Cloud Run client.
+func GetCloudRunQuotas(ctx context.Context, sc *serviceusage.APIService, clients map[string]*run.APIService, project string) (*CloudRunQuotas, error) {
+	out := &CloudRunQuotas{
+		MaxServicesPerRegion:     defaultMaxServicesPerRegion,
+		CurrentServicesPerRegion: make(map[string]int64, len(clients)),
+		Limits:                   make(map[string]map[string]int64),
+	}
+	err := sc.Services.ConsumerQuotaMetrics.List(fmt.Sprintf("projects/%s/services/run.googleapis.com", project)).
+		View("BASIC").
+		Pages(ctx, func(resp *serviceusage.ListConsumerQuotaMetricsResponse) error {
+			for _, m := range resp.Metrics {
+				for _, l := range m.ConsumerQuotaLimits {
+					key := m.Metric + " " + l.Unit
+					if out.Limits[key] == nil {
+						out.Limits[key] = make(map[string]int64)
+					}
+					for _, b := range l.QuotaBuckets {
+						out.Limits[key][b.Dimensions["region"]] = b.EffectiveLimit
+					}
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quota metrics: %w", apiError(err))
+	}
+	if v, ok := out.Limit(writeRequestsQuota, ""); ok {
+		out.MaxConcurrentDeployments = v
+	}
+
+	for region, c := range clients {
+		svcs, err := ListServices(ctx, c, region, project, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to count services in %s: %w", region, err)
+		}
+		out.CurrentServicesPerRegion[region] = int64(len(svcs))
+	}
+	return out, nil
+}
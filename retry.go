@@ -0,0 +1,92 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// RetryOptions controls how WithRetry retries a failing call.
+type RetryOptions struct {
+	// MaxAttempts is the number of calls made at most, including the
+	// first one.
+	MaxAttempts int
+	// InitialDelay is the delay before the first retry.
+	InitialDelay time.Duration
+	// MaxDelay caps the delay between two attempts.
+	MaxDelay time.Duration
+	// Multiplier grows the delay after every retry.
+	Multiplier float64
+	// RetryOn reports whether a call failing with the error is worth
+	// retrying. If nil, only transient API errors (429, 5xx) are retried.
+	RetryOn func(error) bool
+}
+
+// DefaultRetryOptions makes up to 5 attempts, starting with a 500ms delay
+// doubling up to 30s, retrying transient API errors.
+func DefaultRetryOptions() RetryOptions {
+	return RetryOptions{
+		MaxAttempts:  5,
+		InitialDelay: time.Millisecond * 500,
+		MaxDelay:     time.Second * 30,
+		Multiplier:   2,
+	}
+}
+
+// withDefaults fills in zero values so a literal RetryOptions{} still
+// works.
+func (o RetryOptions) withDefaults() RetryOptions {
+	d := DefaultRetryOptions()
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = d.MaxAttempts
+	}
+	if o.InitialDelay <= 0 {
+		o.InitialDelay = d.InitialDelay
+	}
+	if o.MaxDelay <= 0 {
+		o.MaxDelay = d.MaxDelay
+	}
+	if o.Multiplier < 1 {
+		o.Multiplier = d.Multiplier
+	}
+	if o.RetryOn == nil {
+		o.RetryOn = isTransient
+	}
+	return o
+}
+
+// WithRetry calls fn until it succeeds, fails with an error not worth
+// retrying, or runs out of attempts, and returns its last error. It stops
+// waiting for the next attempt once ctx is done, returning ctx.Err().
+func WithRetry(ctx context.Context, fn func() error, opts RetryOptions) error {
+	opts = opts.withDefaults()
+	delay := opts.InitialDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= opts.MaxAttempts || !opts.RetryOn(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = time.Duration(float64(delay) * opts.Multiplier)
+		if delay > opts.MaxDelay {
+			delay = opts.MaxDelay
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"google.golang.org/api/run/v1"
+)
+
+// ListRevisions returns all revisions of the service, newest first.
+func ListRevisions(ctx context.Context, c *run.APIService, region, project, name string) ([]*run.Revision, error) {
+	var out []*run.Revision
+	call := c.Namespaces.Revisions.List("namespaces/" + project).
+		LabelSelector("serving.knative.dev/service=" + name).Context(ctx)
+	for token := ""; ; {
+		resp, err := call.Continue(token).Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list revisions: %w", apiError(err))
+		}
+		out = append(out, resp.Items...)
+		if resp.Metadata == nil || resp.Metadata.Continue == "" {
+			break
+		}
+		token = resp.Metadata.Continue
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return creationTime(out[i].Metadata).After(creationTime(out[j].Metadata))
+	})
+	return out, nil
+}
+
+// GetRevision returns the revision with the given name, or ErrNotFound if
+// there's no such revision.
+func GetRevision(c *run.APIService, region, project, revisionName string) (*run.Revision, error) {
+	rev, err := c.Namespaces.Revisions.Get(fmt.Sprintf("namespaces/%s/revisions/%s", project, revisionName)).Do()
+	if err == nil {
+		return rev, nil
+	}
+	if v := ParseGoogleAPIError(err); v != nil && v.HTTPCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: revision %q", ErrNotFound, revisionName)
+	}
+	return nil, fmt.Errorf("failed to get revision: %w", apiError(err))
+}
+
+// DeleteOldRevisions deletes the revisions of the service that are not
+// referenced by its traffic configuration, except for the keep most recent
+// ones. Deletions are attempted one by one and their errors are collected.
+// It returns the number of revisions deleted.
+func DeleteOldRevisions(ctx context.Context, c *run.APIService, region, project, name string, keep int) (int, error) {
+	if keep < 0 {
+		return 0, fmt.Errorf("keep must be non-negative, got %d", keep)
+	}
+	svc, err := getService(c, region, project, name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get service: %w", err)
+	}
+	revs, err := ListRevisions(ctx, c, region, project, name)
+	if err != nil {
+		return 0, err
+	}
+
+	inUse := make(map[string]bool)
+	for _, t := range svc.Spec.Traffic {
+		inUse[t.RevisionName] = true
+	}
+	if svc.Status != nil {
+		for _, t := range svc.Status.Traffic {
+			inUse[t.RevisionName] = true
+		}
+		// targets following the latest revision don't name it explicitly.
+		inUse[svc.Status.LatestReadyRevisionName] = true
+		inUse[svc.Status.LatestCreatedRevisionName] = true
+	}
+
+	var unused []*run.Revision
+	for _, r := range revs { // already newest first
+		if !inUse[r.Metadata.Name] {
+			unused = append(unused, r)
+		}
+	}
+	if len(unused) <= keep {
+		return 0, nil
+	}
+
+	var errs multiError
+	deleted := 0
+	for _, r := range unused[keep:] {
+		_, err := c.Namespaces.Revisions.Delete(fmt.Sprintf("namespaces/%s/revisions/%s", project, r.Metadata.Name)).Context(ctx).Do()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete revision %q: %w", r.Metadata.Name, apiError(err)))
+			continue
+		}
+		deleted++
+	}
+	if len(errs) > 0 {
+		return deleted, errs
+	}
+	return deleted, nil
+}
+
+func revisionCondition(rev *run.Revision, condition string) *run.GoogleCloudRunV1Condition {
+	if rev.Status == nil {
+		return nil
+	}
+	for _, c := range rev.Status.Conditions {
+		if c.Type == condition {
+			return c
+		}
+	}
+	return nil
+}
+
+// creationTime parses the RFC3339 creationTimestamp of an object, or
+// returns the zero time if it's missing or malformed.
+func creationTime(m *run.ObjectMeta) time.Time {
+	if m == nil {
+		return time.Time{}
+	}
+	t, _ := time.Parse(time.RFC3339, m.CreationTimestamp)
+	return t
+}
+
+// GetLatestRevisionName returns the name of the revision created by the
+// latest deployment of the service, which might not be ready yet.
+// ErrNoRevision is returned if the API hasn't reported it yet.
+func GetLatestRevisionName(svc *run.Service) (string, error) {
+	if svc.Status == nil || svc.Status.LatestCreatedRevisionName == "" {
+		return "", fmt.Errorf("%w: service has no revision yet", ErrNoRevision)
+	}
+	return svc.Status.LatestCreatedRevisionName, nil
+}
+
+// GetLatestReadyRevisionName returns the name of the latest revision of
+// the service that became ready, or ErrNoRevision if there's none.
+func GetLatestReadyRevisionName(svc *run.Service) (string, error) {
+	if svc.Status == nil || svc.Status.LatestReadyRevisionName == "" {
+		return "", fmt.Errorf("%w: service has no ready revision yet", ErrNoRevision)
+	}
+	return svc.Status.LatestReadyRevisionName, nil
+}
+
+// FindRevisionByAnnotation returns the newest revision of the service
+// annotated with key set to value, such as a git commit set with
+// AnnotateRevision, or ErrNotFound if there's none.
+func FindRevisionByAnnotation(ctx context.Context, c *run.APIService, region, project, serviceName, annotationKey, annotationValue string) (*run.Revision, error) {
+	revs, err := ListRevisions(ctx, c, region, project, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	for _, rev := range revs {
+		if v, ok := rev.Metadata.Annotations[annotationKey]; ok && v == annotationValue {
+			return rev, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: revision of service %q with %s=%s", ErrNotFound, serviceName, annotationKey, annotationValue)
+}
+
+// CloneRevisionSpec returns a revision template reproducing src, with the
+// image of its first container replaced by newImage (unless empty), to be
+// assigned to svc.Spec.Template. This is handy to roll out a fix on top of
+// a known good revision. newRevisionName can be empty to let the API pick
+// the name.
+//
+// The whole spec of src is copied, including the volumes its containers
+// mount, along with the annotations of src that are not set by the server.
+func CloneRevisionSpec(src *run.Revision, newRevisionName, newImage string) *run.RevisionTemplate {
+	var spec run.RevisionSpec
+	if src.Spec != nil {
+		// the generated types always marshal, so errors can't happen here.
+		b, _ := json.Marshal(src.Spec)
+		_ = json.Unmarshal(b, &spec)
+	}
+	if newImage != "" && len(spec.Containers) > 0 {
+		spec.Containers[0].Image = newImage
+	}
+	meta := &run.ObjectMeta{Name: newRevisionName}
+	if src.Metadata != nil && len(src.Metadata.Annotations) > 0 {
+		meta.Annotations = make(map[string]string, len(src.Metadata.Annotations))
+		for k, v := range src.Metadata.Annotations {
+			meta.Annotations[k] = v
+		}
+		for _, k := range serverAnnotations {
+			delete(meta.Annotations, k)
+		}
+	}
+	return &run.RevisionTemplate{Metadata: meta, Spec: &spec}
+}
+
+// GetRevisionContainerPorts returns the ports declared by each container of
+// rev, by container name (which can be empty in single container
+// revisions). Containers declaring no port are left out, so a revision
+// relying on the default port 8080 yields an empty map.
+func GetRevisionContainerPorts(rev *run.Revision) map[string][]int64 {
+	out := make(map[string][]int64)
+	if rev.Spec == nil {
+		return out
+	}
+	for _, c := range rev.Spec.Containers {
+		for _, p := range c.Ports {
+			out[c.Name] = append(out[c.Name], p.ContainerPort)
+		}
+	}
+	return out
+}
+
+// DeployEvent is the creation of a revision of a service.
+type DeployEvent struct {
+	RevisionName string
+	// Image is the image as given by the deployer, such as a tag, rather
+	// than the digest it was resolved to.
+	Image      string
+	DeployedAt time.Time
+	// DeployedBy is the principal that created the revision, if known.
+	DeployedBy string
+}
+
+// ServiceEventHistory returns the deployments of the service that still
+// have a revision, oldest first.
+func ServiceEventHistory(ctx context.Context, c *run.APIService, region, project, name string) ([]DeployEvent, error) {
+	revs, err := ListRevisions(ctx, c, region, project, name)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]DeployEvent, 0, len(revs))
+	for _, rev := range revs {
+		image := rev.Metadata.Annotations["client.knative.dev/user-image"]
+		if image == "" && rev.Spec != nil && len(rev.Spec.Containers) > 0 {
+			image = rev.Spec.Containers[0].Image
+		}
+		out = append(out, DeployEvent{
+			RevisionName: rev.Metadata.Name,
+			Image:        image,
+			DeployedAt:   creationTime(rev.Metadata),
+			DeployedBy:   rev.Metadata.Annotations["serving.knative.dev/creator"],
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].DeployedAt.Before(out[j].DeployedAt)
+	})
+	return out, nil
+}
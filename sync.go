@@ -0,0 +1,234 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+
+	"google.golang.org/api/run/v1"
+)
+
+// SyncServiceFromConfig deploys the service defined in configFile, a YAML
+// or JSON file in the format accepted by ImportServiceYAML, unless the
+// live service already matches it, in which case ErrNoChanges is returned.
+//
+// The live service matches if it has every field set in the file to the
+// same value, and no labels, annotations, environment variables or
+// resource limits that were left out of the file. Other fields left out
+// of the file are not compared, as the server fills in defaults for most
+// of them, and neither are the labels and annotations Cloud Run manages
+// itself, such as the run.googleapis.com/ ones, unless set in the file.
+func SyncServiceFromConfig(ctx context.Context, c *run.APIService, region, project, configFile string) (*run.Service, error) {
+	b, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	desired, err := ImportServiceYAML(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", configFile, err)
+	}
+	// the file describes the desired state, updates are always made on
+	// top of the latest version of the service.
+	stripServerFields(desired)
+
+	live, err := getService(c, region, project, desired.Metadata.Name)
+	if err != nil {
+		if v := ParseGoogleAPIError(err); v == nil || v.HTTPCode != http.StatusNotFound {
+			return nil, fmt.Errorf("failed to get service: %w", err)
+		}
+		live = nil
+	}
+	if live != nil {
+		inSync, err := serviceMatches(live, desired)
+		if err != nil {
+			return nil, err
+		}
+		if inSync {
+			return nil, ErrNoChanges
+		}
+	}
+	return DeployService(ctx, c, region, project, desired)
+}
+
+// serviceMatches reports whether every field set in desired has the same
+// value in live, and live has no user set labels, annotations, environment
+// variables or resource limits that desired doesn't.
+func serviceMatches(live, desired *run.Service) (bool, error) {
+	var l, d interface{}
+	for _, v := range []struct {
+		svc *run.Service
+		out *interface{}
+	}{{live, &l}, {desired, &d}} {
+		b, err := json.Marshal(v.svc)
+		if err != nil {
+			return false, fmt.Errorf("failed to compare services: %w", err)
+		}
+		if err := json.Unmarshal(b, v.out); err != nil {
+			return false, fmt.Errorf("failed to compare services: %w", err)
+		}
+		// env vars are compared regardless of order by containerMatches.
+		dropContainerEnv(*v.out)
+	}
+	if !jsonSubset(d, l) {
+		return false, nil
+	}
+	if !metadataMatches(live.Metadata, desired.Metadata) {
+		return false, nil
+	}
+	lt, dt := templateOrEmpty(live), templateOrEmpty(desired)
+	if !metadataMatches(lt.Metadata, dt.Metadata) {
+		return false, nil
+	}
+	if dt.Spec == nil {
+		return true, nil
+	}
+	// jsonSubset already made sure both have the same number of containers.
+	for i, dc := range dt.Spec.Containers {
+		if !containerMatches(lt.Spec.Containers[i], dc) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// templateOrEmpty returns the revision template of svc, or an empty one if
+// it has none, without initializing it like revisionTemplate does.
+func templateOrEmpty(svc *run.Service) *run.RevisionTemplate {
+	if svc.Spec == nil || svc.Spec.Template == nil {
+		return &run.RevisionTemplate{}
+	}
+	return svc.Spec.Template
+}
+
+// metadataMatches reports whether live and desired have the same user set
+// labels and annotations.
+func metadataMatches(live, desired *run.ObjectMeta) bool {
+	if live == nil {
+		live = &run.ObjectMeta{}
+	}
+	if desired == nil {
+		desired = &run.ObjectMeta{}
+	}
+	return userKeysMatch(live.Labels, desired.Labels) &&
+		userKeysMatch(live.Annotations, desired.Annotations)
+}
+
+// userKeysMatch reports whether live has the same keys and values as
+// desired, ignoring the server managed keys of live missing in desired.
+func userKeysMatch(live, desired map[string]string) bool {
+	for k, v := range desired {
+		if lv, ok := live[k]; !ok || lv != v {
+			return false
+		}
+	}
+	for k := range live {
+		if _, ok := desired[k]; !ok && !serverManagedKey(k) {
+			return false
+		}
+	}
+	return true
+}
+
+// serverManagedKey reports whether a label or annotation key is one that
+// Cloud Run sets on its own.
+func serverManagedKey(k string) bool {
+	for _, prefix := range []string{"serving.knative.dev/", "run.googleapis.com/", "cloud.googleapis.com/"} {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// containerMatches reports whether live has the same environment variables
+// as desired, and the same resource limits if desired sets any. Without
+// limits, a container gets the default ones, so they're not compared.
+func containerMatches(live, desired *run.Container) bool {
+	if len(live.Env) != len(desired.Env) {
+		return false
+	}
+	env := make(map[string]*run.EnvVar, len(live.Env))
+	for _, e := range live.Env {
+		env[e.Name] = e
+	}
+	for _, e := range desired.Env {
+		l, ok := env[e.Name]
+		if !ok || !reflect.DeepEqual(l, e) {
+			return false
+		}
+	}
+	if desired.Resources == nil || len(desired.Resources.Limits) == 0 {
+		return true
+	}
+	var limits map[string]string
+	if live.Resources != nil {
+		limits = live.Resources.Limits
+	}
+	return reflect.DeepEqual(limits, desired.Resources.Limits)
+}
+
+// dropContainerEnv removes the env of the containers from svc, a decoded
+// JSON service.
+func dropContainerEnv(svc interface{}) {
+	for _, k := range []string{"spec", "template", "spec"} {
+		m, _ := svc.(map[string]interface{})
+		svc = m[k]
+	}
+	m, _ := svc.(map[string]interface{})
+	containers, _ := m["containers"].([]interface{})
+	for _, c := range containers {
+		if c, ok := c.(map[string]interface{}); ok {
+			delete(c, "env")
+		}
+	}
+}
+
+// jsonSubset reports whether have contains want, where want and have are
+// decoded JSON values. Objects in have may have more keys than in want;
+// arrays must be of the same length with each element contained.
+func jsonSubset(want, have interface{}) bool {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		h, ok := have.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range w {
+			if !jsonSubset(v, h[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		h, ok := have.([]interface{})
+		if !ok || len(h) != len(w) {
+			return false
+		}
+		for i := range w {
+			if !jsonSubset(w[i], h[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return want == have
+	}
+}
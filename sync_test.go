@@ -0,0 +1,187 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"google.golang.org/api/run/v1"
+)
+
+func TestJSONSubset(t *testing.T) {
+	tests := []struct {
+		name       string
+		want, have string
+		subset     bool
+	}{
+		{"equal scalars", `1`, `1`, true},
+		{"different scalars", `"a"`, `"b"`, false},
+		{"extra keys in have", `{"a":1}`, `{"a":1,"b":2}`, true},
+		{"missing key in have", `{"a":1,"b":2}`, `{"a":1}`, false},
+		{"nested objects", `{"a":{"b":1}}`, `{"a":{"b":1,"c":2}}`, true},
+		{"nested mismatch", `{"a":{"b":1}}`, `{"a":{"b":2}}`, false},
+		{"arrays of same length", `[{"a":1}]`, `[{"a":1,"b":2}]`, true},
+		{"arrays of different length", `[1]`, `[1,2]`, false},
+		{"array order matters", `[1,2]`, `[2,1]`, false},
+		{"type mismatch", `{"a":1}`, `[1]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want, have interface{}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.have), &have); err != nil {
+				t.Fatal(err)
+			}
+			if got := jsonSubset(want, have); got != tt.subset {
+				t.Errorf("jsonSubset(%s, %s) = %v, want %v", tt.want, tt.have, got, tt.subset)
+			}
+		})
+	}
+}
+
+func TestServiceMatches(t *testing.T) {
+	svc := func(labels, annotations map[string]string, env []*run.EnvVar, limits map[string]string) *run.Service {
+		c := &run.Container{Image: "gcr.io/p/app", Env: env}
+		if limits != nil {
+			c.Resources = &run.ResourceRequirements{Limits: limits}
+		}
+		return &run.Service{
+			Metadata: &run.ObjectMeta{Name: "app", Labels: labels, Annotations: annotations},
+			Spec: &run.ServiceSpec{Template: &run.RevisionTemplate{
+				Metadata: &run.ObjectMeta{},
+				Spec:     &run.RevisionSpec{Containers: []*run.Container{c}},
+			}},
+		}
+	}
+	env := func(kv ...string) []*run.EnvVar {
+		var out []*run.EnvVar
+		for i := 0; i < len(kv); i += 2 {
+			out = append(out, &run.EnvVar{Name: kv[i], Value: kv[i+1]})
+		}
+		return out
+	}
+	tests := []struct {
+		name          string
+		live, desired *run.Service
+		want          bool
+	}{
+		{
+			name:    "same",
+			live:    svc(map[string]string{"a": "1"}, nil, env("K", "v"), map[string]string{"cpu": "1"}),
+			desired: svc(map[string]string{"a": "1"}, nil, env("K", "v"), map[string]string{"cpu": "1"}),
+			want:    true,
+		},
+		{
+			name:    "label removed",
+			live:    svc(map[string]string{"a": "1", "b": "2"}, nil, nil, nil),
+			desired: svc(map[string]string{"a": "1"}, nil, nil, nil),
+			want:    false,
+		},
+		{
+			name:    "server managed label ignored",
+			live:    svc(map[string]string{"a": "1", "cloud.googleapis.com/location": "us-central1"}, nil, nil, nil),
+			desired: svc(map[string]string{"a": "1"}, nil, nil, nil),
+			want:    true,
+		},
+		{
+			name:    "annotation removed",
+			live:    svc(nil, map[string]string{"team": "x"}, nil, nil),
+			desired: svc(nil, nil, nil, nil),
+			want:    false,
+		},
+		{
+			name: "server managed annotations ignored",
+			live: svc(nil, map[string]string{
+				"serving.knative.dev/creator":  "me@example.com",
+				"run.googleapis.com/ingress":   "all",
+				"cloud.googleapis.com/version": "1",
+			}, nil, nil),
+			desired: svc(nil, nil, nil, nil),
+			want:    true,
+		},
+		{
+			name:    "server managed annotation set in config",
+			live:    svc(nil, map[string]string{"run.googleapis.com/ingress": "all"}, nil, nil),
+			desired: svc(nil, map[string]string{"run.googleapis.com/ingress": "internal"}, nil, nil),
+			want:    false,
+		},
+		{
+			name:    "env var removed",
+			live:    svc(nil, nil, env("A", "1", "B", "2"), nil),
+			desired: svc(nil, nil, env("A", "1"), nil),
+			want:    false,
+		},
+		{
+			name:    "env vars reordered",
+			live:    svc(nil, nil, env("A", "1", "B", "2"), nil),
+			desired: svc(nil, nil, env("B", "2", "A", "1"), nil),
+			want:    true,
+		},
+		{
+			name:    "all env vars removed",
+			live:    svc(nil, nil, env("A", "1"), nil),
+			desired: svc(nil, nil, nil, nil),
+			want:    false,
+		},
+		{
+			name:    "resource limit removed",
+			live:    svc(nil, nil, nil, map[string]string{"cpu": "1", "memory": "1Gi"}),
+			desired: svc(nil, nil, nil, map[string]string{"cpu": "1"}),
+			want:    false,
+		},
+		{
+			name:    "default limits not compared",
+			live:    svc(nil, nil, nil, map[string]string{"cpu": "1000m", "memory": "512Mi"}),
+			desired: svc(nil, nil, nil, nil),
+			want:    true,
+		},
+		{
+			name:    "value changed",
+			live:    svc(nil, nil, env("A", "1"), nil),
+			desired: svc(nil, nil, env("A", "2"), nil),
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := serviceMatches(tt.live, tt.desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("serviceMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceMatchesTemplateLabels(t *testing.T) {
+	live := &run.Service{Spec: &run.ServiceSpec{Template: &run.RevisionTemplate{
+		Metadata: &run.ObjectMeta{Labels: map[string]string{"a": "1", "b": "2"}},
+	}}}
+	desired := &run.Service{Spec: &run.ServiceSpec{Template: &run.RevisionTemplate{
+		Metadata: &run.ObjectMeta{Labels: map[string]string{"a": "1"}},
+	}}}
+	got, err := serviceMatches(live, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got {
+		t.Error("serviceMatches() = true for a template label removed from the config")
+	}
+}
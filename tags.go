@@ -0,0 +1,151 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/run/v1"
+)
+
+var tagRe = regexp.MustCompile(`^[a-z][a-z0-9-]{0,62}$`)
+
+// TagRevision gives a revision of the service its own URL, such as
+// https://tag---service-hash.a.run.app, without sending it any of the
+// traffic of the service URL. It waits for the new route to take effect.
+// An existing tag that receives no traffic is moved to the revision.
+func TagRevision(ctx context.Context, c *run.APIService, region, project, serviceName, revisionName, tag string) error {
+	if !tagRe.MatchString(tag) {
+		return fmt.Errorf("invalid tag %q, must match %s", tag, tagRe)
+	}
+	rev, err := GetRevision(c, region, project, revisionName)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if rev == nil || rev.Metadata.Labels["serving.knative.dev/service"] != serviceName {
+		return fmt.Errorf("%w: %q in service %q", ErrRevisionNotFound, revisionName, serviceName)
+	}
+
+	svc, err := getService(c, region, project, serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to get service: %w", err)
+	}
+	var found bool
+	for _, t := range svc.Spec.Traffic {
+		if t.Tag != tag {
+			continue
+		}
+		if t.RevisionName == revisionName && !t.LatestRevision {
+			return nil
+		}
+		if t.Percent != 0 {
+			return fmt.Errorf("tag %q is already used by a target receiving %d%% of traffic", tag, t.Percent)
+		}
+		t.RevisionName, t.LatestRevision = revisionName, false
+		found = true
+	}
+	if !found {
+		svc.Spec.Traffic = append(svc.Spec.Traffic, &run.TrafficTarget{
+			Tag:          tag,
+			RevisionName: revisionName,
+			Percent:      0,
+		})
+	}
+	if _, err := replaceService(ctx, c, project, svc, DeployOptions{}); err != nil {
+		return err
+	}
+	return waitForReady(ctx, c, region, project, serviceName, "RoutesReady", DefaultWaitOptions())
+}
+
+// UntagRevision removes tag from the traffic configuration of the service,
+// and waits for the new route to take effect. ErrTagNotFound is returned
+// if there's no such tag. If the tagged target receives traffic,
+// ErrTagHasTraffic is returned and the traffic has to be sent elsewhere
+// first.
+func UntagRevision(ctx context.Context, c *run.APIService, region, project, serviceName, tag string) error {
+	svc, err := getService(c, region, project, serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to get service: %w", err)
+	}
+	var found bool
+	traffic := make([]*run.TrafficTarget, 0, len(svc.Spec.Traffic))
+	for _, t := range svc.Spec.Traffic {
+		if t.Tag != tag {
+			traffic = append(traffic, t)
+			continue
+		}
+		if t.Percent != 0 {
+			return fmt.Errorf("%w: %q receives %d%% of traffic", ErrTagHasTraffic, tag, t.Percent)
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("%w: %q in service %q", ErrTagNotFound, tag, serviceName)
+	}
+	svc.Spec.Traffic = traffic
+	if _, err := replaceService(ctx, c, project, svc, DeployOptions{}); err != nil {
+		return err
+	}
+	return waitForReady(ctx, c, region, project, serviceName, "RoutesReady", DefaultWaitOptions())
+}
+
+// GetServingRevisionForTag returns the name of the revision that tag
+// points to in the traffic configuration of svc, resolving a tag on the
+// latest revision to the latest ready one. It returns ErrTagNotFound if
+// there's no such tag.
+func GetServingRevisionForTag(svc *run.Service, tag string) (string, error) {
+	if svc.Spec != nil {
+		for _, t := range svc.Spec.Traffic {
+			if t.Tag != tag {
+				continue
+			}
+			if t.LatestRevision {
+				return GetLatestReadyRevisionName(svc)
+			}
+			return t.RevisionName, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrTagNotFound, tag)
+}
+
+// GetTaggedRevisionURLs returns the URL of each tag the service serves, by
+// tag, such as https://tag---service-hash-uc.a.run.app. The API reports
+// them in the traffic status, and they're otherwise derived from the URL
+// of the service.
+func GetTaggedRevisionURLs(svc *run.Service) map[string]string {
+	out := make(map[string]string)
+	if svc.Status == nil {
+		return out
+	}
+	var host string
+	if svc.Status.Address != nil {
+		host = strings.TrimPrefix(svc.Status.Address.Url, "https://")
+	}
+	for _, t := range svc.Status.Traffic {
+		if t.Tag == "" {
+			continue
+		}
+		if t.Url != "" {
+			out[t.Tag] = t.Url
+		} else if host != "" {
+			out[t.Tag] = "https://" + t.Tag + "---" + host
+		}
+	}
+	return out
+}
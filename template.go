@@ -0,0 +1,476 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/run/v1"
+)
+
+// Most per-revision settings of Cloud Run are annotations on the revision
+// template, see https://cloud.google.com/run/docs/reference/rest/v1/RevisionTemplate.
+
+// revisionTemplate returns the revision template of svc, initializing it
+// and its metadata and spec as needed.
+func revisionTemplate(svc *run.Service) *run.RevisionTemplate {
+	if svc.Spec == nil {
+		svc.Spec = &run.ServiceSpec{}
+	}
+	if svc.Spec.Template == nil {
+		svc.Spec.Template = &run.RevisionTemplate{}
+	}
+	if svc.Spec.Template.Metadata == nil {
+		svc.Spec.Template.Metadata = &run.ObjectMeta{}
+	}
+	if svc.Spec.Template.Spec == nil {
+		svc.Spec.Template.Spec = &run.RevisionSpec{}
+	}
+	return svc.Spec.Template
+}
+
+// templateAnnotations returns the annotations of the revision template of
+// svc, initializing them as needed.
+func templateAnnotations(svc *run.Service) map[string]string {
+	m := revisionTemplate(svc).Metadata
+	if m.Annotations == nil {
+		m.Annotations = make(map[string]string)
+	}
+	return m.Annotations
+}
+
+// ConfigureVPCConnector routes the egress traffic of new revisions through
+// a Serverless VPC Access connector. egressSetting is either "all-traffic"
+// or "private-ranges-only".
+func ConfigureVPCConnector(svc *run.Service, connectorName, egressSetting string) error {
+	if connectorName == "" {
+		return fmt.Errorf("connector name is not set")
+	}
+	switch egressSetting {
+	case "all-traffic", "private-ranges-only":
+	default:
+		return fmt.Errorf("invalid vpc egress setting %q (valid values: all-traffic, private-ranges-only)", egressSetting)
+	}
+	a := templateAnnotations(svc)
+	a["run.googleapis.com/vpc-access-connector"] = connectorName
+	a["run.googleapis.com/vpc-access-egress"] = egressSetting
+	return nil
+}
+
+// networkTagRe matches valid network tags.
+var networkTagRe = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// networkInterface is the format of the entries of the
+// run.googleapis.com/network-interfaces annotation.
+type networkInterface struct {
+	Network    string   `json:"network,omitempty"`
+	Subnetwork string   `json:"subnetwork,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+}
+
+// ConfigureDirectVPCEgress sends the egress traffic of new revisions
+// directly into a VPC network, without a connector. Either the network or
+// the subnetwork can be left empty to use the one matching the other, and
+// tags are network tags to apply firewall rules by. Egress is further
+// controlled by the same run.googleapis.com/vpc-access-egress annotation as
+// with a connector.
+//
+// A revision cannot use both, so ErrIncompatibleWithConnector is returned
+// if a connector was configured with ConfigureVPCConnector.
+func ConfigureDirectVPCEgress(svc *run.Service, network, subnetwork string, tags []string) error {
+	if network == "" && subnetwork == "" {
+		return fmt.Errorf("one of network or subnetwork must be set")
+	}
+	for _, t := range tags {
+		if !networkTagRe.MatchString(t) {
+			return fmt.Errorf("invalid network tag %q", t)
+		}
+	}
+	a := templateAnnotations(svc)
+	if v := a["run.googleapis.com/vpc-access-connector"]; v != "" {
+		return fmt.Errorf("%w %q, remove it first", ErrIncompatibleWithConnector, v)
+	}
+	b, err := json.Marshal([]networkInterface{{Network: network, Subnetwork: subnetwork, Tags: tags}})
+	if err != nil {
+		return fmt.Errorf("failed to encode network interfaces: %w", err)
+	}
+	a["run.googleapis.com/network-interfaces"] = string(b)
+	return nil
+}
+
+// SetConcurrencyAndTimeouts sets the maximum number of concurrent requests
+// per container instance (1-1000) and the request timeout in seconds
+// (1-3600) of new revisions.
+func SetConcurrencyAndTimeouts(svc *run.Service, maxConcurrency int64, timeoutSeconds int64) error {
+	if maxConcurrency < 1 || maxConcurrency > 1000 {
+		return fmt.Errorf("invalid container concurrency %d, must be between 1 and 1000", maxConcurrency)
+	}
+	if timeoutSeconds < 1 || timeoutSeconds > 3600 {
+		return fmt.Errorf("%w %ds, must be between 1 and 3600 seconds", ErrInvalidTimeout, timeoutSeconds)
+	}
+	spec := revisionTemplate(svc).Spec
+	spec.ContainerConcurrency = maxConcurrency
+	spec.TimeoutSeconds = timeoutSeconds
+	return nil
+}
+
+// SetRequestTimeout sets how long new revisions have to respond to a
+// request, a whole number of seconds between 1s and 1h.
+func SetRequestTimeout(svc *run.Service, d time.Duration) error {
+	if d < time.Second || d > time.Hour || d%time.Second != 0 {
+		return fmt.Errorf("%w %v, must be a whole number of seconds between 1s and 1h0m0s", ErrInvalidTimeout, d)
+	}
+	revisionTemplate(svc).Spec.TimeoutSeconds = int64(d / time.Second)
+	return nil
+}
+
+// maxInstancesLimit is the highest max-instances value Cloud Run accepts.
+const maxInstancesLimit = 1000
+
+// ConfigureMinMaxInstances sets the autoscaling bounds of new revisions.
+// Other annotations of the revision template are left untouched.
+func ConfigureMinMaxInstances(svc *run.Service, minScale, maxScale int) error {
+	if minScale < 0 || maxScale < 1 || minScale > maxScale {
+		return fmt.Errorf("invalid instance bounds min=%d max=%d, must satisfy 0 <= min <= max and max >= 1", minScale, maxScale)
+	}
+	if maxScale > maxInstancesLimit {
+		return fmt.Errorf("invalid max instances %d, must be at most %d", maxScale, maxInstancesLimit)
+	}
+	a := templateAnnotations(svc)
+	a["autoscaling.knative.dev/minScale"] = strconv.Itoa(minScale)
+	a["autoscaling.knative.dev/maxScale"] = strconv.Itoa(maxScale)
+	return nil
+}
+
+var (
+	serviceAccountRe        = regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]@[a-z0-9.-]+\.iam\.gserviceaccount\.com$`)
+	computeServiceAccountRe = regexp.MustCompile(`^[0-9]+-compute@developer\.gserviceaccount\.com$`)
+)
+
+// SetServiceAccount sets the identity new revisions run as. The email must
+// be a user-managed service account or the Compute Engine default one.
+func SetServiceAccount(svc *run.Service, serviceAccountEmail string) error {
+	if !serviceAccountRe.MatchString(serviceAccountEmail) && !computeServiceAccountRe.MatchString(serviceAccountEmail) {
+		return fmt.Errorf("%w: %q", ErrInvalidServiceAccount, serviceAccountEmail)
+	}
+	revisionTemplate(svc).Spec.ServiceAccountName = serviceAccountEmail
+	return nil
+}
+
+// cloudSQLInstanceRe matches project:region:instance connection names,
+// where the project ID may be domain-scoped (example.com:project).
+var cloudSQLInstanceRe = regexp.MustCompile(`^([a-z0-9.-]+:)?[a-z][a-z0-9-]*[a-z0-9]:[a-z]+-[a-z]+[0-9]+:[a-z][a-z0-9-]*$`)
+
+// ConfigureCloudSQLConnections makes the given Cloud SQL instances
+// available to new revisions, replacing any previously configured ones.
+// An empty list removes the Cloud SQL connections altogether.
+func ConfigureCloudSQLConnections(svc *run.Service, instanceConnectionNames []string) error {
+	var problems []string
+	var names []string
+	seen := make(map[string]bool)
+	for _, n := range instanceConnectionNames {
+		if !cloudSQLInstanceRe.MatchString(n) {
+			problems = append(problems, fmt.Sprintf("invalid instance connection name %q, expected project:region:instance", n))
+			continue
+		}
+		if !seen[n] {
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	a := templateAnnotations(svc)
+	if len(names) == 0 {
+		delete(a, "run.googleapis.com/cloudsql-instances")
+		return nil
+	}
+	a["run.googleapis.com/cloudsql-instances"] = strings.Join(names, ",")
+	return nil
+}
+
+// ExecutionEnvironment selects the sandbox revisions run in.
+type ExecutionEnvironment string
+
+const (
+	Gen1 ExecutionEnvironment = "gen1"
+	Gen2 ExecutionEnvironment = "gen2"
+)
+
+// SetExecutionEnvironment sets the execution environment of new revisions.
+func SetExecutionEnvironment(svc *run.Service, env ExecutionEnvironment) error {
+	if env != Gen1 && env != Gen2 {
+		return fmt.Errorf("invalid execution environment %q (valid values: %s, %s)", env, Gen1, Gen2)
+	}
+	templateAnnotations(svc)["run.googleapis.com/execution-environment"] = string(env)
+	return nil
+}
+
+// maxContainers is the most containers a Cloud Run revision can have.
+const maxContainers = 10
+
+// AddSidecarContainer appends sidecar to the containers of new revisions.
+// The first container stays the one serving requests, so sidecar must not
+// declare a port. With dependsOnMain, the sidecar is only started after the
+// main container has started.
+func AddSidecarContainer(svc *run.Service, sidecar *run.Container, dependsOnMain bool) error {
+	spec := revisionTemplate(svc).Spec
+	if len(spec.Containers) == 0 {
+		return fmt.Errorf("service has no main container to add a sidecar to")
+	}
+	if len(spec.Containers) >= maxContainers {
+		return fmt.Errorf("revisions can have at most %d containers", maxContainers)
+	}
+	if sidecar.Name == "" {
+		return fmt.Errorf("sidecar container must have a name")
+	}
+	if len(sidecar.Ports) > 0 {
+		return fmt.Errorf("sidecar container %q must not declare a port, only the main container receives requests", sidecar.Name)
+	}
+	for _, c := range spec.Containers {
+		if c.Name == sidecar.Name {
+			return fmt.Errorf("there's already a container named %q", sidecar.Name)
+		}
+	}
+	if dependsOnMain {
+		mainName := spec.Containers[0].Name
+		if mainName == "" {
+			return fmt.Errorf("main container must have a name for the sidecar to depend on it")
+		}
+		a := templateAnnotations(svc)
+		deps := make(map[string][]string)
+		if v := a["run.googleapis.com/container-dependencies"]; v != "" {
+			if err := json.Unmarshal([]byte(v), &deps); err != nil {
+				return fmt.Errorf("failed to parse existing container dependencies: %w", err)
+			}
+		}
+		deps[sidecar.Name] = append(deps[sidecar.Name], mainName)
+		b, err := json.Marshal(deps)
+		if err != nil {
+			return fmt.Errorf("failed to encode container dependencies: %w", err)
+		}
+		a["run.googleapis.com/container-dependencies"] = string(b)
+	}
+	spec.Containers = append(spec.Containers, sidecar)
+	return nil
+}
+
+// sessionAffinityRegions are the regions known to support session
+// affinity.
+var sessionAffinityRegions = map[string]bool{
+	"africa-south1": true, "asia-east1": true, "asia-east2": true,
+	"asia-northeast1": true, "asia-northeast2": true, "asia-northeast3": true,
+	"asia-south1": true, "asia-south2": true, "asia-southeast1": true,
+	"asia-southeast2": true, "australia-southeast1": true, "australia-southeast2": true,
+	"europe-central2": true, "europe-north1": true, "europe-southwest1": true,
+	"europe-west1": true, "europe-west2": true, "europe-west3": true,
+	"europe-west4": true, "europe-west6": true, "europe-west8": true,
+	"europe-west9": true, "europe-west12": true, "me-central1": true,
+	"me-west1": true, "northamerica-northeast1": true, "northamerica-northeast2": true,
+	"southamerica-east1": true, "southamerica-west1": true, "us-central1": true,
+	"us-east1": true, "us-east4": true, "us-east5": true, "us-south1": true,
+	"us-west1": true, "us-west2": true, "us-west3": true, "us-west4": true,
+}
+
+// SetSessionAffinity makes new revisions route the requests of a client to
+// the same instance when possible. The region of the service is only known
+// from its cloud.googleapis.com/location label, present on services read
+// from the API; enabling session affinity in a region not known to support
+// it returns ErrNotSupportedInRegion.
+func SetSessionAffinity(svc *run.Service, enabled bool) error {
+	if region := serviceMetadata(svc).Labels["cloud.googleapis.com/location"]; enabled && region != "" && !sessionAffinityRegions[region] {
+		return fmt.Errorf("%w: session affinity in %s", ErrNotSupportedInRegion, region)
+	}
+	templateAnnotations(svc)["run.googleapis.com/sessionAffinity"] = strconv.FormatBool(enabled)
+	return nil
+}
+
+// SetStartupCPUBoost gives new revisions extra CPU while their instances
+// start, shortening cold starts. The boost applies with either CPU
+// allocation mode, but the run.googleapis.com/cpu-throttling annotation
+// selecting it must be valid for the revision to be accepted, so a
+// malformed one is reported when enabling the boost.
+func SetStartupCPUBoost(svc *run.Service, enabled bool) error {
+	a := templateAnnotations(svc)
+	if v, ok := a["run.googleapis.com/cpu-throttling"]; enabled && ok {
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("cannot enable startup cpu boost: invalid cpu allocation mode %q in run.googleapis.com/cpu-throttling, must be true or false", v)
+		}
+	}
+	if !enabled {
+		delete(a, "run.googleapis.com/startup-cpu-boost")
+		return nil
+	}
+	a["run.googleapis.com/startup-cpu-boost"] = "true"
+	return nil
+}
+
+// SetAlwaysOnCPU selects whether new revisions keep their CPU allocated
+// outside of requests. Always-on CPU is billed for the whole lifetime of
+// instances, so it requires minimum instances to be set first with
+// ConfigureMinMaxInstances.
+func SetAlwaysOnCPU(svc *run.Service, alwaysOn bool) error {
+	a := templateAnnotations(svc)
+	if alwaysOn {
+		if n, err := strconv.Atoi(a["autoscaling.knative.dev/minScale"]); err != nil || n < 1 {
+			return fmt.Errorf("always-on cpu requires min instances to be at least 1, got %q", a["autoscaling.knative.dev/minScale"])
+		}
+	}
+	a["run.googleapis.com/cpu-throttling"] = strconv.FormatBool(!alwaysOn)
+	return nil
+}
+
+// GPUType is a kind of GPU that Cloud Run instances can be attached to.
+type GPUType string
+
+const (
+	GPUNvidiaL4 GPUType = "nvidia-l4"
+)
+
+// GPUConfig is the GPU setup of a revision.
+type GPUConfig struct {
+	Type  GPUType
+	Count int
+}
+
+func (g GPUConfig) validate() error {
+	if g.Type != GPUNvidiaL4 {
+		return fmt.Errorf("invalid gpu type %q (valid values: %s)", g.Type, GPUNvidiaL4)
+	}
+	if g.Count != 1 {
+		return fmt.Errorf("invalid gpu count %d, instances can only have 1 gpu", g.Count)
+	}
+	return nil
+}
+
+// GPU instances need at least this much CPU and memory.
+const (
+	gpuMinCPU       = 4
+	gpuMinMemoryMiB = 16 * 1024
+)
+
+// ConfigureGPU attaches count GPUs of the given type to the instances of
+// new revisions, by requesting them for the main container. GPUs need CPU
+// to be always allocated, so CPU throttling is turned off; GPU services can
+// still scale to zero. They also need at least 4 CPUs and 16Gi of memory:
+// limits left unset are raised to these, lower ones are rejected.
+func ConfigureGPU(svc *run.Service, gpuType GPUType, count int) error {
+	g := GPUConfig{Type: gpuType, Count: count}
+	if err := g.validate(); err != nil {
+		return err
+	}
+	spec := revisionTemplate(svc).Spec
+	if len(spec.Containers) == 0 {
+		return fmt.Errorf("service has no container to attach gpus to")
+	}
+	container := spec.Containers[0]
+	if container.Resources == nil {
+		container.Resources = &run.ResourceRequirements{}
+	}
+	limits := container.Resources.Limits
+	if v, ok := limits["cpu"]; ok && cpuCores(v) < gpuMinCPU {
+		return fmt.Errorf("invalid cpu limit %q for gpu instances, must be at least %d", v, gpuMinCPU)
+	}
+	if v, ok := limits["memory"]; ok && memoryMiB(v) < gpuMinMemoryMiB {
+		return fmt.Errorf("invalid memory limit %q for gpu instances, must be at least %dGi", v, gpuMinMemoryMiB/1024)
+	}
+	if limits == nil {
+		limits = make(map[string]string)
+		container.Resources.Limits = limits
+	}
+	if _, ok := limits["cpu"]; !ok {
+		limits["cpu"] = strconv.Itoa(gpuMinCPU)
+	}
+	if _, ok := limits["memory"]; !ok {
+		limits["memory"] = fmt.Sprintf("%dGi", gpuMinMemoryMiB/1024)
+	}
+	limits["nvidia.com/gpu"] = strconv.Itoa(g.Count)
+	a := templateAnnotations(svc)
+	a["run.googleapis.com/cpu-throttling"] = "false"
+	a["run.googleapis.com/accelerator"] = string(g.Type)
+	return nil
+}
+
+// headerNameRe matches header names, which are tokens per RFC 7230.
+var headerNameRe = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// AddResponseHeader has new revisions add a header to every response. A
+// header added before with the same name is replaced. Headers Cloud Run
+// sets itself, named X-Cloud-Run-*, return ErrReservedHeader.
+func AddResponseHeader(svc *run.Service, key, value string) error {
+	if !headerNameRe.MatchString(key) {
+		return fmt.Errorf("invalid header name %q", key)
+	}
+	if strings.HasPrefix(strings.ToLower(key), "x-cloud-run-") {
+		return fmt.Errorf("%w %q", ErrReservedHeader, key)
+	}
+	if strings.ContainsAny(value, ",\r\n") {
+		return fmt.Errorf("invalid value %q for header %q, must not contain commas or line breaks", value, key)
+	}
+	a := templateAnnotations(svc)
+	var headers []string
+	if v := a["run.googleapis.com/custom-response-headers"]; v != "" {
+		for _, h := range strings.Split(v, ",") {
+			if name, _, _ := strings.Cut(h, ":"); !strings.EqualFold(strings.TrimSpace(name), key) {
+				headers = append(headers, h)
+			}
+		}
+	}
+	headers = append(headers, key+":"+value)
+	a["run.googleapis.com/custom-response-headers"] = strings.Join(headers, ",")
+	return nil
+}
+
+// ScaleToZero lets new revisions scale down to no instances when idle, as
+// suits staging environments: minimum instances are set to 0 and CPU is
+// only allocated during requests. If maxInstances is positive, it also
+// becomes the maximum number of instances.
+//
+// Instances that are shut down lose their in-memory state, so a warning is
+// logged to the logger of ctx if the service appears to rely on it,
+// through session affinity or background work with always-on CPU. GPU
+// services can scale to zero too, but are warned about since removing
+// always-on CPU goes against what GPUs need. This is why ctx is taken,
+// next to maxInstances which spares callers a separate call to
+// ConfigureMinMaxInstances.
+func ScaleToZero(ctx context.Context, svc *run.Service, maxInstances int) error {
+	if maxInstances > maxInstancesLimit {
+		return fmt.Errorf("invalid max instances %d, must be at most %d", maxInstances, maxInstancesLimit)
+	}
+	a := templateAnnotations(svc)
+	name := serviceMetadata(svc).Name
+	if v, ok := a["run.googleapis.com/accelerator"]; ok {
+		loggerFrom(ctx).Warn("removing always-on cpu from a service with gpus", "service", name, "gpu", v)
+	}
+	if a["run.googleapis.com/sessionAffinity"] == "true" {
+		loggerFrom(ctx).Warn("scaling to zero a service using session affinity, sessions will be lost", "service", name)
+	}
+	if a["run.googleapis.com/cpu-throttling"] == "false" {
+		loggerFrom(ctx).Warn("removing always-on cpu, background work will be throttled", "service", name)
+	}
+	a["autoscaling.knative.dev/minScale"] = "0"
+	delete(a, "run.googleapis.com/cpu-throttling")
+	if maxInstances > 0 {
+		a["autoscaling.knative.dev/maxScale"] = strconv.Itoa(maxInstances)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/run/v1"
+)
+
+// testService returns a service with one container and the given revision
+// template annotations.
+func testService(annotations map[string]string) *run.Service {
+	return &run.Service{
+		Metadata: &run.ObjectMeta{Name: "app"},
+		Spec: &run.ServiceSpec{Template: &run.RevisionTemplate{
+			Metadata: &run.ObjectMeta{Annotations: annotations},
+			Spec: &run.RevisionSpec{Containers: []*run.Container{{
+				Image: "gcr.io/p/app",
+			}}},
+		}},
+	}
+}
+
+func TestConfigureGPU(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		limits      map[string]string
+		wantErr     bool
+		wantLimits  map[string]string
+	}{
+		{
+			name:        "defaults raised to minimums",
+			annotations: map[string]string{"autoscaling.knative.dev/minScale": "1"},
+			wantLimits:  map[string]string{"cpu": "4", "memory": "16Gi", "nvidia.com/gpu": "1"},
+		},
+		{
+			name:        "larger limits kept",
+			annotations: map[string]string{"autoscaling.knative.dev/minScale": "1"},
+			limits:      map[string]string{"cpu": "8", "memory": "32Gi"},
+			wantLimits:  map[string]string{"cpu": "8", "memory": "32Gi", "nvidia.com/gpu": "1"},
+		},
+		{
+			name:       "no min instances",
+			wantLimits: map[string]string{"cpu": "4", "memory": "16Gi", "nvidia.com/gpu": "1"},
+		},
+		{
+			name:        "cpu too low",
+			annotations: map[string]string{"autoscaling.knative.dev/minScale": "1"},
+			limits:      map[string]string{"cpu": "2"},
+			wantErr:     true,
+		},
+		{
+			name:        "memory too low",
+			annotations: map[string]string{"autoscaling.knative.dev/minScale": "1"},
+			limits:      map[string]string{"memory": "8Gi"},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := testService(tt.annotations)
+			if tt.limits != nil {
+				svc.Spec.Template.Spec.Containers[0].Resources = &run.ResourceRequirements{Limits: tt.limits}
+			}
+			err := ConfigureGPU(svc, GPUNvidiaL4, 1)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("ConfigureGPU() succeeded, want error")
+				}
+				if _, ok := svc.Spec.Template.Metadata.Annotations["run.googleapis.com/accelerator"]; ok {
+					t.Error("ConfigureGPU() set the accelerator despite failing")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConfigureGPU() = %v", err)
+			}
+			a := svc.Spec.Template.Metadata.Annotations
+			if a["run.googleapis.com/accelerator"] != "nvidia-l4" || a["run.googleapis.com/cpu-throttling"] != "false" {
+				t.Errorf("annotations = %v", a)
+			}
+			got := svc.Spec.Template.Spec.Containers[0].Resources.Limits
+			if len(got) != len(tt.wantLimits) {
+				t.Fatalf("limits = %v, want %v", got, tt.wantLimits)
+			}
+			for k, v := range tt.wantLimits {
+				if got[k] != v {
+					t.Errorf("limits = %v, want %v", got, tt.wantLimits)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigureMinMaxInstances(t *testing.T) {
+	tests := []struct {
+		min, max int
+		wantErr  bool
+	}{
+		{0, 1, false},
+		{0, 100, false},
+		{3, 3, false},
+		{0, maxInstancesLimit, false},
+		{0, 0, true},
+		{-1, 10, true},
+		{5, 4, true},
+		{0, maxInstancesLimit + 1, true},
+	}
+	for _, tt := range tests {
+		svc := testService(nil)
+		err := ConfigureMinMaxInstances(svc, tt.min, tt.max)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ConfigureMinMaxInstances(%d, %d) = %v, want error: %v", tt.min, tt.max, err, tt.wantErr)
+		}
+	}
+}
+
+func TestScaleToZero(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := contextWithLogger(context.Background(), slog.New(slog.NewTextHandler(&buf, nil)))
+	svc := testService(map[string]string{
+		"autoscaling.knative.dev/minScale":   "2",
+		"run.googleapis.com/cpu-throttling":  "false",
+		"run.googleapis.com/sessionAffinity": "true",
+	})
+	if err := ScaleToZero(ctx, svc, 5); err != nil {
+		t.Fatal(err)
+	}
+	a := svc.Spec.Template.Metadata.Annotations
+	if a["autoscaling.knative.dev/minScale"] != "0" || a["autoscaling.knative.dev/maxScale"] != "5" {
+		t.Errorf("annotations = %v", a)
+	}
+	if _, ok := a["run.googleapis.com/cpu-throttling"]; ok {
+		t.Errorf("cpu-throttling annotation was kept: %v", a)
+	}
+	if n := strings.Count(buf.String(), "level=WARN"); n != 2 {
+		t.Errorf("got %d warnings, want 2:\n%s", n, buf.String())
+	}
+
+	buf.Reset()
+	gpu := testService(nil)
+	if err := ConfigureGPU(gpu, GPUNvidiaL4, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ScaleToZero(ctx, gpu, 0); err != nil {
+		t.Fatalf("ScaleToZero() = %v for a service with a gpu", err)
+	}
+	a = gpu.Spec.Template.Metadata.Annotations
+	if a["autoscaling.knative.dev/minScale"] != "0" {
+		t.Errorf("annotations = %v", a)
+	}
+	if _, ok := a["run.googleapis.com/cpu-throttling"]; ok {
+		t.Errorf("cpu-throttling annotation was kept: %v", a)
+	}
+	if !strings.Contains(buf.String(), "gpu=nvidia-l4") {
+		t.Errorf("no gpu warning logged:\n%s", buf.String())
+	}
+}
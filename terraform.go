@@ -0,0 +1,278 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/run/v1"
+)
+
+var hclIdentInvalidRe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// ServiceToTerraformHCL returns Terraform configuration declaring svc as a
+// google_cloud_run_service resource, so a service deployed by other means
+// can be brought under Terraform, such as with terraform import. Fields
+// managed by the server are left out. Secrets the service reads are
+// declared as google_secret_manager_secret_version data sources, in the
+// project the run.googleapis.com/secrets annotation names for aliased
+// ones, or else in project.
+func ServiceToTerraformHCL(svc *run.Service, region, project string) (string, error) {
+	if svc.Metadata == nil || svc.Metadata.Name == "" {
+		return "", fmt.Errorf("service name is not set")
+	}
+	if svc.Spec == nil {
+		return "", fmt.Errorf("service has no spec")
+	}
+	svc, err := copyService(svc)
+	if err != nil {
+		return "", err
+	}
+	stripServerFields(svc)
+	delete(svc.Metadata.Labels, "cloud.googleapis.com/location")
+
+	w := &hclWriter{}
+	secrets := make(map[string][3]string) // data source name to project, secret, version
+	w.open(`resource "google_cloud_run_service" %s`, hclString(hclIdent(svc.Metadata.Name)))
+	w.attr("name", hclString(svc.Metadata.Name))
+	w.attr("location", hclString(region))
+	w.attr("project", hclString(project))
+	if len(svc.Metadata.Annotations) > 0 || len(svc.Metadata.Labels) > 0 {
+		w.open("metadata")
+		w.mapAttr("annotations", svc.Metadata.Annotations)
+		w.mapAttr("labels", svc.Metadata.Labels)
+		w.close()
+	}
+
+	var aliases map[string]string
+	if tmpl := svc.Spec.Template; tmpl != nil && tmpl.Metadata != nil {
+		aliases = secretAliases(tmpl.Metadata.Annotations)
+	}
+	if tmpl := svc.Spec.Template; tmpl != nil {
+		w.open("template")
+		if m := tmpl.Metadata; m != nil && (m.Name != "" || len(m.Annotations) > 0 || len(m.Labels) > 0) {
+			w.open("metadata")
+			if m.Name != "" {
+				w.attr("name", hclString(m.Name))
+			}
+			w.mapAttr("annotations", m.Annotations)
+			w.mapAttr("labels", m.Labels)
+			w.close()
+		}
+		if spec := tmpl.Spec; spec != nil {
+			w.open("spec")
+			if spec.ServiceAccountName != "" {
+				w.attr("service_account_name", hclString(spec.ServiceAccountName))
+			}
+			if spec.ContainerConcurrency > 0 {
+				w.attr("container_concurrency", fmt.Sprint(spec.ContainerConcurrency))
+			}
+			if spec.TimeoutSeconds > 0 {
+				w.attr("timeout_seconds", fmt.Sprint(spec.TimeoutSeconds))
+			}
+			for _, c := range spec.Containers {
+				w.open("containers")
+				if c.Name != "" {
+					w.attr("name", hclString(c.Name))
+				}
+				w.attr("image", hclString(c.Image))
+				if len(c.Command) > 0 {
+					w.attr("command", hclList(c.Command))
+				}
+				if len(c.Args) > 0 {
+					w.attr("args", hclList(c.Args))
+				}
+				for _, p := range c.Ports {
+					w.open("ports")
+					if p.Name != "" {
+						w.attr("name", hclString(p.Name))
+					}
+					w.attr("container_port", fmt.Sprint(p.ContainerPort))
+					w.close()
+				}
+				for _, e := range c.Env {
+					w.open("env")
+					w.attr("name", hclString(e.Name))
+					if ref := secretRef(e); ref != nil {
+						ds := hclIdent(ref.Name + "_" + ref.Key)
+						name := "data.google_secret_manager_secret_version." + ds + ".secret"
+						secretProject, secret := project, ref.Name
+						if m := secretNameRe.FindStringSubmatch(aliases[ref.Name]); m != nil {
+							// the run.googleapis.com/secrets annotation, exported
+							// above, resolves the alias.
+							secretProject, secret = m[1], m[2]
+							name = hclString(ref.Name)
+						}
+						secrets[ds] = [3]string{secretProject, secret, ref.Key}
+						w.open("value_from")
+						w.open("secret_key_ref")
+						w.attr("name", name)
+						w.attr("key", "data.google_secret_manager_secret_version."+ds+".version")
+						w.close()
+						w.close()
+					} else {
+						w.attr("value", hclString(e.Value))
+					}
+					w.close()
+				}
+				if c.Resources != nil && len(c.Resources.Limits) > 0 {
+					w.open("resources")
+					w.mapAttr("limits", c.Resources.Limits)
+					w.close()
+				}
+				for _, m := range c.VolumeMounts {
+					w.open("volume_mounts")
+					w.attr("name", hclString(m.Name))
+					w.attr("mount_path", hclString(m.MountPath))
+					w.close()
+				}
+				w.close()
+			}
+			for _, v := range spec.Volumes {
+				if v.Secret == nil {
+					return "", fmt.Errorf("volume %q is not a secret volume, only those are supported", v.Name)
+				}
+				w.open("volumes")
+				w.attr("name", hclString(v.Name))
+				w.open("secret")
+				w.attr("secret_name", hclString(v.Secret.SecretName))
+				for _, it := range v.Secret.Items {
+					w.open("items")
+					w.attr("key", hclString(it.Key))
+					w.attr("path", hclString(it.Path))
+					w.close()
+				}
+				w.close()
+				w.close()
+			}
+			w.close()
+		}
+		w.close()
+	}
+
+	for _, t := range svc.Spec.Traffic {
+		w.open("traffic")
+		w.attr("percent", fmt.Sprint(t.Percent))
+		if t.LatestRevision {
+			w.attr("latest_revision", "true")
+		} else {
+			w.attr("revision_name", hclString(t.RevisionName))
+		}
+		if t.Tag != "" {
+			w.attr("tag", hclString(t.Tag))
+		}
+		w.close()
+	}
+	w.close()
+
+	names := make([]string, 0, len(secrets))
+	for ds := range secrets {
+		names = append(names, ds)
+	}
+	sort.Strings(names)
+	for _, ds := range names {
+		w.line("")
+		w.open(`data "google_secret_manager_secret_version" %s`, hclString(ds))
+		w.attr("project", hclString(secrets[ds][0]))
+		w.attr("secret", hclString(secrets[ds][1]))
+		w.attr("version", hclString(secrets[ds][2]))
+		w.close()
+	}
+	return w.String(), nil
+}
+
+func secretRef(e *run.EnvVar) *run.SecretKeySelector {
+	if e.ValueFrom == nil {
+		return nil
+	}
+	return e.ValueFrom.SecretKeyRef
+}
+
+// hclWriter writes indented HCL blocks.
+type hclWriter struct {
+	strings.Builder
+	depth int
+}
+
+func (w *hclWriter) line(format string, args ...interface{}) {
+	if format != "" {
+		w.WriteString(strings.Repeat("  ", w.depth))
+		fmt.Fprintf(w, format, args...)
+	}
+	w.WriteString("\n")
+}
+
+func (w *hclWriter) open(format string, args ...interface{}) {
+	w.line(format+" {", args...)
+	w.depth++
+}
+
+func (w *hclWriter) close() {
+	w.depth--
+	w.line("}")
+}
+
+func (w *hclWriter) attr(name, value string) {
+	w.line("%s = %s", name, value)
+}
+
+// mapAttr writes m as an object attribute with sorted keys, or nothing if
+// it's empty.
+func (w *hclWriter) mapAttr(name string, m map[string]string) {
+	if len(m) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	w.line("%s = {", name)
+	w.depth++
+	for _, k := range keys {
+		w.attr(hclString(k), hclString(m[k]))
+	}
+	w.depth--
+	w.line("}")
+}
+
+// hclString quotes s as an HCL string literal, escaping template
+// sequences so it's taken literally.
+func hclString(s string) string {
+	b, _ := json.Marshal(s)
+	q := strings.ReplaceAll(string(b), "${", "$${")
+	return strings.ReplaceAll(q, "%{", "%%{")
+}
+
+func hclList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, s := range items {
+		quoted[i] = hclString(s)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// hclIdent turns s into a valid Terraform resource name.
+func hclIdent(s string) string {
+	s = hclIdentInvalidRe.ReplaceAllString(s, "_")
+	if s == "" || s[0] == '-' || (s[0] >= '0' && s[0] <= '9') {
+		s = "_" + s
+	}
+	return s
+}
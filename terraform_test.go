@@ -0,0 +1,90 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/api/run/v1"
+)
+
+func TestServiceToTerraformHCL(t *testing.T) {
+	svc := goldenService()
+	tmpl := svc.Spec.Template
+	if err := AddSecretEnvVar(tmpl, tmpl.Spec.Containers[0], "SHARED_KEY", "projects/shared-secrets/secrets/api-key", "3"); err != nil {
+		t.Fatal(err)
+	}
+	// db-password is taken by the secret of the service's own project.
+	if err := AddSecretEnvVar(tmpl, tmpl.Spec.Containers[0], "SHARED_DB_PASSWORD", "projects/shared-secrets/secrets/db-password", "latest"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ServiceToTerraformHCL(svc, "us-central1", "my-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "terraform.golden", []byte(got))
+}
+
+func TestServiceToTerraformHCLErrors(t *testing.T) {
+	for name, svc := range map[string]*run.Service{
+		"no name": {Metadata: &run.ObjectMeta{}, Spec: &run.ServiceSpec{}},
+		"no spec": {Metadata: &run.ObjectMeta{Name: "api"}},
+	} {
+		if _, err := ServiceToTerraformHCL(svc, "us-central1", "p"); err == nil {
+			t.Errorf("ServiceToTerraformHCL() with %s succeeded, want error", name)
+		}
+	}
+}
+
+func TestHCLString(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"plain", `"plain"`},
+		{`quote " and \ backslash`, `"quote \" and \\ backslash"`},
+		{"line\nbreak", `"line\nbreak"`},
+		{"${var.x}", `"$${var.x}"`},
+		{"%{ if true }", `"%%{ if true }"`},
+		{"100% $5", `"100% $5"`},
+	}
+	for _, tt := range tests {
+		if got := hclString(tt.in); got != tt.want {
+			t.Errorf("hclString(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHCLIdent(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"api", "api"},
+		{"my-service", "my-service"},
+		{"db.password", "db_password"},
+		{"1st", "_1st"},
+		{"-x", "_-x"},
+		{"", "_"},
+	}
+	for _, tt := range tests {
+		if got := hclIdent(tt.in); got != tt.want {
+			t.Errorf("hclIdent(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHCLList(t *testing.T) {
+	if got, want := hclList(nil), "[]"; got != want {
+		t.Errorf("hclList(nil) = %s, want %s", got, want)
+	}
+	if got, want := hclList([]string{"a", "${b}"}), `["a", "$${b}"]`; got != want {
+		t.Errorf("hclList() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,413 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/api/run/v1"
+)
+
+// RollbackToRevision sends 100% of the traffic of the service to an
+// existing revision of it, and waits for the new route to take effect.
+// Tagged targets are kept.
+func RollbackToRevision(ctx context.Context, c *run.APIService, region, project, serviceName, revisionName string) error {
+	rev, err := GetRevision(c, region, project, revisionName)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if rev == nil || rev.Metadata.Labels["serving.knative.dev/service"] != serviceName {
+		return fmt.Errorf("%w: %q in service %q", ErrRevisionNotFound, revisionName, serviceName)
+	}
+	if cond := revisionCondition(rev, "Ready"); cond != nil && cond.Status == "False" {
+		return fmt.Errorf("%w: %q (reason:%s) %s", ErrRevisionFailed, revisionName, cond.Reason, cond.Message)
+	}
+
+	svc, err := getService(c, region, project, serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to get service: %w", err)
+	}
+	svc.Spec.Traffic = trafficTargets(svc.Spec.Traffic, map[string]int64{revisionName: 100})
+	if _, err := replaceService(ctx, c, project, svc, DeployOptions{}); err != nil {
+		return err
+	}
+	return waitForReady(ctx, c, region, project, serviceName, "RoutesReady", DefaultWaitOptions())
+}
+
+// SetTrafficByPercent sends the traffic of the service by the given
+// revision name to percent split, and waits for the new route to take
+// effect. The percentages must add up to 100. Tagged targets are kept,
+// receiving no traffic unless their revision is in split.
+func SetTrafficByPercent(ctx context.Context, c *run.APIService, region, project, name string, split map[string]int64) error {
+	var total int64
+	for rev, p := range split {
+		if p < 0 || p > 100 {
+			return fmt.Errorf("invalid traffic percent %d for revision %q", p, rev)
+		}
+		total += p
+	}
+	if total != 100 {
+		return fmt.Errorf("traffic percentages must add up to 100, got %d", total)
+	}
+
+	revs, err := ListRevisions(ctx, c, region, project, name)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(revs))
+	for _, r := range revs {
+		known[r.Metadata.Name] = true
+	}
+	for rev := range split {
+		if !known[rev] {
+			return fmt.Errorf("%w: %q in service %q", ErrRevisionNotFound, rev, name)
+		}
+	}
+
+	svc, err := getService(c, region, project, name)
+	if err != nil {
+		return fmt.Errorf("failed to get service: %w", err)
+	}
+	svc.Spec.Traffic = trafficTargets(svc.Spec.Traffic, split)
+	if _, err := replaceService(ctx, c, project, svc, DeployOptions{}); err != nil {
+		return err
+	}
+	return waitForReady(ctx, c, region, project, name, "RoutesReady", DefaultWaitOptions())
+}
+
+// trafficTargets returns the traffic configuration of a service currently
+// configured with live that sends traffic by the given revision name to
+// percent split. The tagged targets of live are kept, so their URLs keep
+// working: a tagged target of a revision in split receives its share, and
+// the others receive no traffic. The rest of split follows as untagged
+// targets ordered by revision name, leaving out the ones with no traffic.
+func trafficTargets(live []*run.TrafficTarget, split map[string]int64) []*run.TrafficTarget {
+	rest := make(map[string]int64, len(split))
+	for rev, p := range split {
+		rest[rev] = p
+	}
+	var out []*run.TrafficTarget
+	for _, t := range live {
+		if t.Tag == "" {
+			continue
+		}
+		kept := &run.TrafficTarget{
+			Tag:            t.Tag,
+			RevisionName:   t.RevisionName,
+			LatestRevision: t.LatestRevision,
+		}
+		if p, ok := rest[t.RevisionName]; ok && !t.LatestRevision {
+			kept.Percent = p
+			delete(rest, t.RevisionName)
+		}
+		out = append(out, kept)
+	}
+	names := make([]string, 0, len(rest))
+	for rev, p := range rest {
+		if p > 0 {
+			names = append(names, rev)
+		}
+	}
+	sort.Strings(names)
+	for _, rev := range names {
+		out = append(out, &run.TrafficTarget{RevisionName: rev, Percent: rest[rev]})
+	}
+	return out
+}
+
+// GradualTrafficMigration moves traffic from oldRev to newRev stepPercent
+// at a time, waiting stepInterval between the steps, until newRev receives
+// all the traffic. The two revisions must be the only ones receiving
+// traffic. If any step fails, the traffic configuration from before the
+// migration is restored.
+func GradualTrafficMigration(ctx context.Context, c *run.APIService, region, project, name, oldRev, newRev string, stepPercent int64, stepInterval time.Duration) error {
+	if stepPercent <= 0 || stepPercent > 100 {
+		return fmt.Errorf("step percent must be between 1 and 100, got %d", stepPercent)
+	}
+	svc, err := getService(c, region, project, name)
+	if err != nil {
+		return fmt.Errorf("failed to get service: %w", err)
+	}
+	original := svc.Spec.Traffic
+	split := currentSplit(svc)
+	for rev, p := range split {
+		if rev != oldRev && rev != newRev && p > 0 {
+			return fmt.Errorf("revision %q receives %d%% of traffic, only %q and %q may receive traffic during migration", rev, p, oldRev, newRev)
+		}
+	}
+	if split[oldRev]+split[newRev] != 100 {
+		return fmt.Errorf("revisions %q and %q must receive all the traffic before migration", oldRev, newRev)
+	}
+
+	for first := true; split[newRev] < 100; first = false {
+		if !first {
+			select {
+			case <-ctx.Done():
+				return rollbackTraffic(c, region, project, name, original, ctx.Err())
+			case <-time.After(stepInterval):
+			}
+		}
+		step := stepPercent
+		if split[oldRev] < step {
+			step = split[oldRev]
+		}
+		split = map[string]int64{oldRev: split[oldRev] - step, newRev: split[newRev] + step}
+		loggerFrom(ctx).Info("shifting traffic", "service", name,
+			"from", oldRev, "from_percent", split[oldRev], "revision", newRev, "percent", split[newRev])
+		if err := SetTrafficByPercent(ctx, c, region, project, name, split); err != nil {
+			return rollbackTraffic(c, region, project, name, original, err)
+		}
+	}
+	return nil
+}
+
+// rollbackTraffic restores the given traffic configuration after a failed
+// migration and returns the error that caused it. It uses its own deadline
+// since the caller's context may be the reason the migration failed.
+func rollbackTraffic(c *run.APIService, region, project, name string, traffic []*run.TrafficTarget, cause error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*120)
+	defer cancel()
+	svc, err := getService(c, region, project, name)
+	if err == nil {
+		svc.Spec.Traffic = traffic
+		_, err = replaceService(ctx, c, project, svc, DeployOptions{})
+	}
+	if err == nil {
+		err = waitForReady(ctx, c, region, project, name, "RoutesReady", DefaultWaitOptions())
+	}
+	if err != nil {
+		return fmt.Errorf("traffic migration failed: %w (rollback also failed: %v)", cause, err)
+	}
+	return fmt.Errorf("traffic migration failed and was rolled back: %w", cause)
+}
+
+// currentSplit returns the desired traffic split of the service, with
+// targets following the latest revision resolved to its name.
+func currentSplit(svc *run.Service) map[string]int64 {
+	out := make(map[string]int64)
+	for _, t := range svc.Spec.Traffic {
+		rev := t.RevisionName
+		if t.LatestRevision && svc.Status != nil {
+			rev = svc.Status.LatestReadyRevisionName
+		}
+		out[rev] += t.Percent
+	}
+	return out
+}
+
+// GetTrafficWeights returns the desired traffic split of the service as
+// revision name to percent, with targets following the latest revision
+// resolved to the latest ready one. Revisions receiving no traffic, like
+// ones that are only tagged, are left out, so equal splits compare equal.
+// The percentages must add up to 100.
+func GetTrafficWeights(svc *run.Service) (map[string]int64, error) {
+	if svc.Spec == nil || len(svc.Spec.Traffic) == 0 {
+		return nil, fmt.Errorf("service has no traffic configuration")
+	}
+	out := make(map[string]int64)
+	var total int64
+	for _, t := range svc.Spec.Traffic {
+		if t.Percent == 0 {
+			continue
+		}
+		rev := t.RevisionName
+		if t.LatestRevision {
+			var err error
+			if rev, err = GetLatestReadyRevisionName(svc); err != nil {
+				return nil, err
+			}
+		}
+		out[rev] += t.Percent
+		total += t.Percent
+	}
+	if total != 100 {
+		return nil, fmt.Errorf("traffic percentages must add up to 100, got %d", total)
+	}
+	return out, nil
+}
+
+// TrafficChange is the change in the traffic of one revision between two
+// versions of a service.
+type TrafficChange struct {
+	RevisionName string
+	OldPercent   int64
+	NewPercent   int64
+	// Tag is the tag of the revision in the new version, or in the old
+	// one if it lost its tag.
+	Tag string
+}
+
+// TrafficDiff returns how the traffic configuration of newSvc differs from
+// that of oldSvc, one change per revision whose percent or tag differs,
+// ordered by revision name. Either service can be nil, such as oldSvc for
+// a service yet to be created. Targets following the latest revision are
+// resolved as in the status of their service, or reported as "LATEST".
+func TrafficDiff(oldSvc, newSvc *run.Service) []TrafficChange {
+	type entry struct {
+		percent int64
+		tag     string
+	}
+	collect := func(svc *run.Service) map[string]entry {
+		out := make(map[string]entry)
+		if svc == nil || svc.Spec == nil {
+			return out
+		}
+		for _, t := range svc.Spec.Traffic {
+			rev := t.RevisionName
+			if t.LatestRevision {
+				rev = "LATEST"
+				if svc.Status != nil && svc.Status.LatestReadyRevisionName != "" {
+					rev = svc.Status.LatestReadyRevisionName
+				}
+			}
+			e := out[rev]
+			e.percent += t.Percent
+			if e.tag == "" {
+				e.tag = t.Tag
+			}
+			out[rev] = e
+		}
+		return out
+	}
+	before, after := collect(oldSvc), collect(newSvc)
+
+	var names []string
+	for rev := range before {
+		names = append(names, rev)
+	}
+	for rev := range after {
+		if _, ok := before[rev]; !ok {
+			names = append(names, rev)
+		}
+	}
+	sort.Strings(names)
+	var out []TrafficChange
+	for _, rev := range names {
+		o, n := before[rev], after[rev]
+		if o == n {
+			continue
+		}
+		tag := n.tag
+		if tag == "" {
+			tag = o.tag
+		}
+		out = append(out, TrafficChange{
+			RevisionName: rev,
+			OldPercent:   o.percent,
+			NewPercent:   n.percent,
+			Tag:          tag,
+		})
+	}
+	return out
+}
+
+// GetEffectiveTraffic returns the traffic split the service is actually
+// serving, as reported in its status, as revision name to percent. Like
+// GetTrafficWeights, tagged and latest revision targets are resolved to
+// revision names and revisions receiving no traffic are left out.
+func GetEffectiveTraffic(svc *run.Service) map[string]int64 {
+	out := make(map[string]int64)
+	if svc.Status == nil {
+		return out
+	}
+	for _, t := range svc.Status.Traffic {
+		if t.Percent == 0 {
+			continue
+		}
+		rev := t.RevisionName
+		if rev == "" && t.LatestRevision {
+			rev = svc.Status.LatestReadyRevisionName
+		}
+		out[rev] += t.Percent
+	}
+	return out
+}
+
+// TrafficIsInSync reports whether the service serves the traffic split it
+// is configured with, meaning the latest traffic change has rolled out.
+func TrafficIsInSync(svc *run.Service) bool {
+	if svc.Status == nil || svc.Metadata == nil || svc.Status.ObservedGeneration != svc.Metadata.Generation {
+		return false
+	}
+	want, err := GetTrafficWeights(svc)
+	if err != nil {
+		return false
+	}
+	have := GetEffectiveTraffic(svc)
+	if len(want) != len(have) {
+		return false
+	}
+	for rev, p := range want {
+		if have[rev] != p {
+			return false
+		}
+	}
+	return true
+}
+
+// PinToCurrentRevision sends all the traffic of the service to its latest
+// ready revision by name, so that later deployments don't receive traffic
+// until it's shifted to them explicitly. Tagged targets are kept. It waits
+// for the new route to take effect and returns the name of the pinned
+// revision.
+func PinToCurrentRevision(ctx context.Context, c *run.APIService, region, project, name string) (string, error) {
+	svc, err := getService(c, region, project, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to get service: %w", err)
+	}
+	rev, err := GetLatestReadyRevisionName(svc)
+	if err != nil {
+		return "", err
+	}
+	svc.Spec.Traffic = trafficTargets(svc.Spec.Traffic, map[string]int64{rev: 100})
+	if _, err := replaceService(ctx, c, project, svc, DeployOptions{}); err != nil {
+		return "", err
+	}
+	if err := waitForReady(ctx, c, region, project, name, "RoutesReady", DefaultWaitOptions()); err != nil {
+		return "", err
+	}
+	return rev, nil
+}
+
+// CopyTrafficConfig gives the service dstName the same traffic split as
+// srcName, such as when promoting a canary from staging to production.
+// revisionMapping maps the revisions of srcName receiving traffic to the
+// revisions of dstName that should receive it instead; a target following
+// the latest revision of srcName is mapped by the name of the latest
+// ready revision. Tags are not copied, and the tagged targets of dstName
+// are kept.
+func CopyTrafficConfig(ctx context.Context, c *run.APIService, region, project, srcName, dstName string, revisionMapping map[string]string) error {
+	src, err := getService(c, region, project, srcName)
+	if err != nil {
+		return fmt.Errorf("failed to get source service: %w", err)
+	}
+	weights, err := GetTrafficWeights(src)
+	if err != nil {
+		return fmt.Errorf("invalid traffic of source service: %w", err)
+	}
+	split := make(map[string]int64, len(weights))
+	for rev, p := range weights {
+		dst, ok := revisionMapping[rev]
+		if !ok {
+			return fmt.Errorf("no destination revision for revision %q of %q, which receives %d%% of traffic", rev, srcName, p)
+		}
+		split[dst] += p
+	}
+	return SetTrafficByPercent(ctx, c, region, project, dstName, split)
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/run/v1"
+)
+
+func TestTrafficTargets(t *testing.T) {
+	tests := []struct {
+		name  string
+		live  []*run.TrafficTarget
+		split map[string]int64
+		want  []*run.TrafficTarget
+	}{
+		{
+			name:  "no live targets",
+			split: map[string]int64{"svc-b": 20, "svc-a": 80},
+			want: []*run.TrafficTarget{
+				{RevisionName: "svc-a", Percent: 80},
+				{RevisionName: "svc-b", Percent: 20},
+			},
+		},
+		{
+			name: "untagged targets are replaced",
+			live: []*run.TrafficTarget{
+				{RevisionName: "svc-a", Percent: 100},
+				{LatestRevision: true, Percent: 0},
+			},
+			split: map[string]int64{"svc-b": 100},
+			want: []*run.TrafficTarget{
+				{RevisionName: "svc-b", Percent: 100},
+			},
+		},
+		{
+			name: "tag only target is kept",
+			live: []*run.TrafficTarget{
+				{RevisionName: "svc-a", Percent: 100},
+				{RevisionName: "svc-b", Tag: "preview"},
+			},
+			split: map[string]int64{"svc-a": 100},
+			want: []*run.TrafficTarget{
+				{RevisionName: "svc-b", Tag: "preview"},
+				{RevisionName: "svc-a", Percent: 100},
+			},
+		},
+		{
+			name: "tagged target receives its share",
+			live: []*run.TrafficTarget{
+				{RevisionName: "svc-a", Percent: 100},
+				{RevisionName: "svc-b", Tag: "preview"},
+			},
+			split: map[string]int64{"svc-a": 90, "svc-b": 10},
+			want: []*run.TrafficTarget{
+				{RevisionName: "svc-b", Tag: "preview", Percent: 10},
+				{RevisionName: "svc-a", Percent: 90},
+			},
+		},
+		{
+			name: "tagged target loses its traffic",
+			live: []*run.TrafficTarget{
+				{RevisionName: "svc-a", Tag: "stable", Percent: 100},
+			},
+			split: map[string]int64{"svc-b": 100},
+			want: []*run.TrafficTarget{
+				{RevisionName: "svc-a", Tag: "stable"},
+				{RevisionName: "svc-b", Percent: 100},
+			},
+		},
+		{
+			name: "tag on latest revision is kept without traffic",
+			live: []*run.TrafficTarget{
+				{LatestRevision: true, Tag: "latest", Percent: 100},
+			},
+			split: map[string]int64{"svc-a": 100},
+			want: []*run.TrafficTarget{
+				{LatestRevision: true, Tag: "latest"},
+				{RevisionName: "svc-a", Percent: 100},
+			},
+		},
+		{
+			name:  "revisions without traffic are left out",
+			split: map[string]int64{"svc-a": 0, "svc-b": 100},
+			want: []*run.TrafficTarget{
+				{RevisionName: "svc-b", Percent: 100},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := trafficTargets(tt.live, tt.split)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("trafficTargets() = %s, want %s", dumpTraffic(got), dumpTraffic(tt.want))
+			}
+		})
+	}
+}
+
+func dumpTraffic(traffic []*run.TrafficTarget) string {
+	var s string
+	for _, t := range traffic {
+		s += fmt.Sprintf("{rev:%q latest:%v tag:%q percent:%d}", t.RevisionName, t.LatestRevision, t.Tag, t.Percent)
+	}
+	return "[" + s + "]"
+}
+
+func TestTrafficDiff(t *testing.T) {
+	svc := func(latestReady string, traffic ...*run.TrafficTarget) *run.Service {
+		s := &run.Service{Spec: &run.ServiceSpec{Traffic: traffic}}
+		if latestReady != "" {
+			s.Status = &run.ServiceStatus{LatestReadyRevisionName: latestReady}
+		}
+		return s
+	}
+	tests := []struct {
+		name     string
+		old, new *run.Service
+		want     []TrafficChange
+	}{
+		{
+			name: "no change",
+			old:  svc("", &run.TrafficTarget{RevisionName: "a", Percent: 100}),
+			new:  svc("", &run.TrafficTarget{RevisionName: "a", Percent: 100}),
+		},
+		{
+			name: "new service",
+			new:  svc("", &run.TrafficTarget{LatestRevision: true, Percent: 100}),
+			want: []TrafficChange{{RevisionName: "LATEST", NewPercent: 100}},
+		},
+		{
+			name: "deleted service",
+			old:  svc("", &run.TrafficTarget{RevisionName: "a", Percent: 100}),
+			want: []TrafficChange{{RevisionName: "a", OldPercent: 100}},
+		},
+		{
+			name: "split",
+			old:  svc("", &run.TrafficTarget{RevisionName: "a", Percent: 100}),
+			new: svc("",
+				&run.TrafficTarget{RevisionName: "b", Percent: 10},
+				&run.TrafficTarget{RevisionName: "a", Percent: 90}),
+			want: []TrafficChange{
+				{RevisionName: "a", OldPercent: 100, NewPercent: 90},
+				{RevisionName: "b", NewPercent: 10},
+			},
+		},
+		{
+			name: "latest resolved",
+			old:  svc("a", &run.TrafficTarget{LatestRevision: true, Percent: 100}),
+			new:  svc("a", &run.TrafficTarget{RevisionName: "a", Percent: 100}),
+		},
+		{
+			name: "tag added",
+			old:  svc("", &run.TrafficTarget{RevisionName: "a", Percent: 100}),
+			new: svc("",
+				&run.TrafficTarget{RevisionName: "a", Percent: 100},
+				&run.TrafficTarget{RevisionName: "b", Tag: "preview"}),
+			want: []TrafficChange{{RevisionName: "b", Tag: "preview"}},
+		},
+		{
+			name: "tag removed",
+			old: svc("",
+				&run.TrafficTarget{RevisionName: "a", Percent: 100},
+				&run.TrafficTarget{RevisionName: "b", Tag: "preview"}),
+			new:  svc("", &run.TrafficTarget{RevisionName: "a", Percent: 100}),
+			want: []TrafficChange{{RevisionName: "b", Tag: "preview"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TrafficDiff(tt.old, tt.new)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TrafficDiff() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
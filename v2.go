@@ -0,0 +1,101 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	runv2 "google.golang.org/api/run/v2"
+)
+
+// The v2 Admin API is not Knative-compatible: services are addressed as
+// projects/*/locations/*/services/* and every mutation returns a
+// long-running operation instead of the updated object.
+
+func serviceNameV2(region, project, name string) string {
+	if strings.HasPrefix(name, "projects/") {
+		return name
+	}
+	return fmt.Sprintf("projects/%s/locations/%s/services/%s", project, region, name)
+}
+
+// DeployServiceV2 creates or updates svc, whose Name can either be the
+// short service name or the full resource name. It waits for the operation
+// to complete and returns the resulting service.
+func DeployServiceV2(ctx context.Context, c *runv2.Service, region, project string, svc *runv2.GoogleCloudRunV2Service) (*runv2.GoogleCloudRunV2Service, error) {
+	if svc.Name == "" {
+		return nil, fmt.Errorf("service name is not set")
+	}
+	desired := *svc
+	desired.Name = serviceNameV2(region, project, svc.Name)
+	// allowMissing turns the patch into a create if the service is absent.
+	op, err := c.Projects.Locations.Services.Patch(desired.Name, &desired).
+		AllowMissing(true).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to deploy service: %w", apiError(err))
+	}
+	op, err = waitForOperationV2(ctx, c, op)
+	if err != nil {
+		return nil, err
+	}
+	var out runv2.GoogleCloudRunV2Service
+	if err := json.Unmarshal(op.Response, &out); err != nil {
+		return nil, fmt.Errorf("failed to decode deployed service: %w", err)
+	}
+	return &out, nil
+}
+
+// GetServiceV2 returns the service, whose name can either be the short
+// service name or the full resource name.
+func GetServiceV2(ctx context.Context, c *runv2.Service, region, project, name string) (*runv2.GoogleCloudRunV2Service, error) {
+	svc, err := c.Projects.Locations.Services.Get(serviceNameV2(region, project, name)).Context(ctx).Do()
+	return svc, apiError(err)
+}
+
+// DeleteServiceV2 deletes the service and waits for the operation to
+// complete.
+func DeleteServiceV2(ctx context.Context, c *runv2.Service, region, project, name string) error {
+	op, err := c.Projects.Locations.Services.Delete(serviceNameV2(region, project, name)).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to delete service: %w", apiError(err))
+	}
+	_, err = waitForOperationV2(ctx, c, op)
+	return err
+}
+
+func waitForOperationV2(ctx context.Context, c *runv2.Service, op *runv2.GoogleLongrunningOperation) (*runv2.GoogleLongrunningOperation, error) {
+	t := time.NewTicker(time.Second * 2)
+	defer t.Stop()
+	for !op.Done {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+			v, err := c.Projects.Locations.Operations.Get(op.Name).Context(ctx).Do()
+			if err != nil {
+				return nil, fmt.Errorf("failed to query operation %s: %w", op.Name, apiError(err))
+			}
+			op = v
+		}
+	}
+	if op.Error != nil {
+		return nil, fmt.Errorf("operation %s failed (code:%d) %s", op.Name, op.Error.Code, op.Error.Message)
+	}
+	return op, nil
+}
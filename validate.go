@@ -0,0 +1,150 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/run/v1"
+)
+
+// serviceNameRe matches valid service names, which additionally must not
+// be longer than maxServiceNameLen.
+var serviceNameRe = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+
+const maxServiceNameLen = 49
+
+// ValidateServiceSpec checks svc for the mistakes the API would reject it
+// for, with clearer messages, without making any API calls. All problems
+// found are returned at once as a *ValidationError.
+func ValidateServiceSpec(svc *run.Service) error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	var name string
+	if svc.Metadata != nil {
+		name = svc.Metadata.Name
+	}
+	if !serviceNameRe.MatchString(name) || len(name) > maxServiceNameLen {
+		add("invalid service name %q, must be up to %d lowercase letters, digits or hyphens, starting with a letter", name, maxServiceNameLen)
+	}
+	if svc.Metadata != nil {
+		for k := range svc.Metadata.Annotations {
+			if !validKey(k) {
+				add("invalid annotation key %q", k)
+			}
+		}
+	}
+
+	var tmpl *run.RevisionTemplate
+	if svc.Spec != nil {
+		tmpl = svc.Spec.Template
+	}
+	if tmpl != nil && tmpl.Metadata != nil {
+		if rev := tmpl.Metadata.Name; rev != "" && (!strings.HasPrefix(rev, name+"-") || rev == name+"-") {
+			add("revision name %q must be the service name followed by a unique suffix, like %s-v1", rev, name)
+		}
+		for k := range tmpl.Metadata.Annotations {
+			if !validKey(k) {
+				add("invalid revision annotation key %q", k)
+			}
+		}
+	}
+	var containers []*run.Container
+	if tmpl != nil && tmpl.Spec != nil {
+		containers = tmpl.Spec.Containers
+	}
+	if len(containers) == 0 {
+		add("at least one container is required")
+	} else if len(containers) > maxContainers {
+		add("at most %d containers are allowed, got %d", maxContainers, len(containers))
+	}
+	hasImage := false
+	for i, c := range containers {
+		if c.Image != "" {
+			hasImage = true
+		}
+		if c.Resources != nil {
+			if cpu, ok := c.Resources.Limits["cpu"]; ok {
+				if n := cpuCores(cpu); n <= 0 || n > 8 {
+					add("invalid cpu limit %q of container %d, must be at most 8", cpu, i)
+				}
+			}
+			if mem, ok := c.Resources.Limits["memory"]; ok {
+				if mib := memoryMiB(mem); mib < 128 || mib > 32*1024 {
+					add("invalid memory limit %q of container %d, must be between 128Mi and 32Gi", mem, i)
+				}
+			}
+		}
+		for _, e := range c.Env {
+			if !envVarNameRe.MatchString(e.Name) {
+				add("invalid environment variable name %q in container %d", e.Name, i)
+			}
+		}
+	}
+	if len(containers) > 0 && !hasImage {
+		add("container image is required")
+	}
+
+	if svc.Spec != nil && len(svc.Spec.Traffic) > 0 {
+		var total int64
+		for _, t := range svc.Spec.Traffic {
+			if t.Percent < 0 || t.Percent > 100 {
+				add("invalid traffic percent %d", t.Percent)
+			}
+			if t.RevisionName == "" && !t.LatestRevision {
+				add("traffic target must have a revision name or follow the latest revision")
+			}
+			total += t.Percent
+		}
+		if total != 100 {
+			add("traffic percentages must add up to 100, got %d", total)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+var serviceNameInvalidRe = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// GenerateServiceName returns a valid service name made from template,
+// such as "review-{suffix}", with suffix (like a branch name) in place of
+// {suffix}. Letters are lowercased and runs of other invalid characters
+// replaced with a hyphen. Names too long are truncated and end with a
+// hash of the whole name instead, so names that only differ past the
+// limit stay distinct, and the same inputs always give the same name.
+func GenerateServiceName(template, suffix string) (string, error) {
+	full := strings.ReplaceAll(template, "{suffix}", suffix)
+	name := serviceNameInvalidRe.ReplaceAllString(strings.ToLower(full), "-")
+	name = strings.Trim(name, "-")
+	if len(name) > maxServiceNameLen {
+		sum := sha256.Sum256([]byte(full))
+		hash := hex.EncodeToString(sum[:4])
+		name = strings.TrimRight(name[:maxServiceNameLen-len(hash)-1], "-") + "-" + hash
+	}
+	if !serviceNameRe.MatchString(name) {
+		return "", fmt.Errorf("cannot make a valid service name out of %q, it must start with a letter", full)
+	}
+	return name, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateServiceName(t *testing.T) {
+	const longBranch = "feature/a-very-long-branch-name-that-goes-on-and-on-forever"
+	tests := []struct {
+		template, suffix string
+		want             string
+		wantErr          bool
+	}{
+		{template: "review-{suffix}", suffix: "pr-123", want: "review-pr-123"},
+		{template: "review-{suffix}", suffix: "Feature/Login_Page", want: "review-feature-login-page"},
+		{template: "review-{suffix}", suffix: "fix..typo!", want: "review-fix-typo"},
+		{template: "{suffix}-api", suffix: "staging", want: "staging-api"},
+		{template: "app", suffix: "ignored", want: "app"},
+		{template: "--{suffix}--", suffix: "x", want: "x"},
+		{template: "review-{suffix}", suffix: longBranch, want: "review-feature-a-very-long-branch-name-t-247f4828"},
+		{template: "{suffix}", suffix: "123-build", wantErr: true},
+		{template: "{suffix}", suffix: "!!!", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := GenerateServiceName(tt.template, tt.suffix)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("GenerateServiceName(%q, %q) = %q, want error", tt.template, tt.suffix, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("GenerateServiceName(%q, %q) = %q, %v, want %q", tt.template, tt.suffix, got, err, tt.want)
+		}
+	}
+}
+
+func TestGenerateServiceNameLong(t *testing.T) {
+	base := strings.Repeat("x", 60)
+	seen := make(map[string]string)
+	for _, suffix := range []string{base + "1", base + "2", base + "-", base + "_"} {
+		name, err := GenerateServiceName("review-{suffix}", suffix)
+		if err != nil {
+			t.Fatalf("GenerateServiceName(%q) = %v", suffix, err)
+		}
+		if len(name) > maxServiceNameLen || !serviceNameRe.MatchString(name) {
+			t.Errorf("GenerateServiceName(%q) = %q, not a valid service name", suffix, name)
+		}
+		if other, ok := seen[name]; ok {
+			t.Errorf("GenerateServiceName(%q) = GenerateServiceName(%q) = %q", suffix, other, name)
+		}
+		seen[name] = suffix
+		if again, _ := GenerateServiceName("review-{suffix}", suffix); again != name {
+			t.Errorf("GenerateServiceName(%q) = %q, then %q", suffix, name, again)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/run/v1"
+)
+
+// WaitOptions controls how often a resource is polled while waiting on it
+// and how transient API errors are retried in the meantime.
+type WaitOptions struct {
+	// PollInterval is the delay between two successful polls.
+	PollInterval time.Duration
+	// MaxRetries is the number of consecutive transient errors tolerated
+	// before the wait gives up.
+	MaxRetries int
+	// BackoffMultiplier grows the delay after every transient error.
+	BackoffMultiplier float64
+	// JitterFraction randomizes each delay by up to ±JitterFraction of it.
+	JitterFraction float64
+}
+
+// DefaultWaitOptions polls every 5 seconds and retries transient errors
+// up to 5 times, doubling the delay each time.
+func DefaultWaitOptions() WaitOptions {
+	return WaitOptions{
+		PollInterval:      time.Second * 5,
+		MaxRetries:        5,
+		BackoffMultiplier: 2,
+		JitterFraction:    0.1,
+	}
+}
+
+// withDefaults fills in zero values so a literal WaitOptions{} still works.
+func (o WaitOptions) withDefaults() WaitOptions {
+	d := DefaultWaitOptions()
+	if o.PollInterval <= 0 {
+		o.PollInterval = d.PollInterval
+	}
+	if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.BackoffMultiplier < 1 {
+		o.BackoffMultiplier = 1
+	}
+	if o.JitterFraction < 0 {
+		o.JitterFraction = 0
+	}
+	return o
+}
+
+// jitter randomizes d by up to ±fraction of its value.
+func (o WaitOptions) jitter(d time.Duration) time.Duration {
+	if o.JitterFraction == 0 {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2-1)*o.JitterFraction*float64(d))
+}
+
+func waitForReady(ctx context.Context, c *run.APIService, region, project, name, condition string, opts WaitOptions) error {
+	return WaitForCondition(ctx, c, region, project, name, conditionTrue(condition), opts)
+}
+
+// conditionTrue returns a WaitForCondition predicate that is done once the
+// condition of the service is True, and fails if it's False.
+func conditionTrue(condition string) func(*run.Service) (bool, error) {
+	return func(svc *run.Service) (bool, error) {
+		for _, c := range svc.Status.Conditions {
+			if c.Type == condition {
+				if c.Status == "True" {
+					return true, nil
+				} else if c.Status == "False" {
+					return false, fmt.Errorf("service could not become %q (status:%s) (reason:%s) %s",
+						condition, c.Status, c.Reason, c.Message)
+				}
+			}
+		}
+		return false, nil
+	}
+}
+
+// WaitWithProgress waits for the condition of the service like
+// waitForReady, and calls onPoll with every snapshot of the service it
+// gets. onPoll is called from another goroutine, one call at a time, so a
+// slow callback does not delay the wait: snapshots arriving while it's
+// busy replace each other, and only the latest one is passed on. The last
+// call may still be running when WaitWithProgress returns.
+func WaitWithProgress(ctx context.Context, c *run.APIService, region, project, name, condition string, opts WaitOptions, onPoll func(*run.Service)) error {
+	snapshots := make(chan *run.Service, 1)
+	defer close(snapshots)
+	go func() {
+		for svc := range snapshots {
+			onPoll(svc)
+		}
+	}()
+	ready := conditionTrue(condition)
+	return WaitForCondition(ctx, c, region, project, name, func(svc *run.Service) (bool, error) {
+		// replace the pending snapshot, if any, without blocking.
+		select {
+		case <-snapshots:
+		default:
+		}
+		snapshots <- svc
+		return ready(svc)
+	}, opts)
+}
+
+// WaitForCondition polls the service until pred reports it's done or
+// returns an error, which is then returned as is.
+func WaitForCondition(ctx context.Context, c *run.APIService, region, project, name string, pred func(*run.Service) (done bool, fatal error), opts WaitOptions) error {
+	return pollWithBackoff(ctx, opts, func() (bool, error, error) {
+		svc, err := getService(c, region, project, name)
+		if err != nil {
+			return false, fmt.Errorf("failed to query service: %w", err), nil
+		}
+		done, err := pred(svc)
+		return done, nil, err
+	})
+}
+
+// GetServiceURL waits until the URL of the service is known and returns
+// it. ErrURLNotAvailable is returned if ctx expires before that.
+func GetServiceURL(ctx context.Context, c *run.APIService, region, project, name string) (string, error) {
+	var url string
+	opts := DefaultWaitOptions()
+	opts.PollInterval = time.Second * 2
+	err := WaitForCondition(ctx, c, region, project, name, func(svc *run.Service) (bool, error) {
+		if svc.Status != nil && svc.Status.Address != nil {
+			url = svc.Status.Address.Url
+		}
+		return url != "", nil
+	}, opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("%w: %w", ErrURLNotAvailable, err)
+		}
+		return "", err
+	}
+	return url, nil
+}
+
+// WaitForRevisionReady polls the revision until its Ready condition is
+// True, and fails as soon as it's False. A revision that does not exist
+// yet is waited for.
+func WaitForRevisionReady(ctx context.Context, c *run.APIService, region, project, revisionName string, opts WaitOptions) error {
+	return pollWithBackoff(ctx, opts, func() (bool, error, error) {
+		rev, err := GetRevision(c, region, project, revisionName)
+		if errors.Is(err, ErrNotFound) {
+			return false, nil, nil
+		} else if err != nil {
+			return false, err, nil
+		}
+		cond := revisionCondition(rev, "Ready")
+		if cond == nil {
+			return false, nil, nil
+		}
+		switch cond.Status {
+		case "True":
+			return true, nil, nil
+		case "False":
+			return false, nil, fmt.Errorf("revision %q could not become ready (reason:%s) %s",
+				revisionName, cond.Reason, cond.Message)
+		}
+		return false, nil, nil
+	})
+}
+
+// WaitForDeletion polls the service until the API no longer returns it,
+// which can take a while after DeleteService. If ctx expires first, the
+// error wraps both ErrStillExists and the error of ctx.
+func WaitForDeletion(ctx context.Context, c *run.APIService, region, project, name string, opts WaitOptions) error {
+	err := pollWithBackoff(ctx, opts, func() (bool, error, error) {
+		_, err := getService(c, region, project, name)
+		if err == nil {
+			return false, nil, nil
+		}
+		if v := ParseGoogleAPIError(err); v != nil && v.HTTPCode == http.StatusNotFound {
+			return true, nil, nil
+		}
+		return false, fmt.Errorf("failed to query service: %w", err), nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%w: service %q: %w", ErrStillExists, name, ctx.Err())
+	}
+	return err
+}
+
+// WaitForRevisionDeletion polls the revision until the API no longer
+// returns it, such as after DeleteOldRevisions. If ctx expires first, the
+// error wraps both ErrStillExists and the error of ctx.
+func WaitForRevisionDeletion(ctx context.Context, c *run.APIService, region, project, revisionName string, opts WaitOptions) error {
+	err := pollWithBackoff(ctx, opts, func() (bool, error, error) {
+		_, err := GetRevision(c, region, project, revisionName)
+		if errors.Is(err, ErrNotFound) {
+			return true, nil, nil
+		}
+		return false, err, nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%w: revision %q: %w", ErrStillExists, revisionName, ctx.Err())
+	}
+	return err
+}
+
+// pollWithBackoff calls step every opts.PollInterval until it's done or
+// returns a fatal error. Errors from querying the API are returned by step
+// as queryErr: transient ones (429, 5xx) are retried with backoff, anything
+// else (like a 403) is not going to fix itself and ends the wait.
+func pollWithBackoff(ctx context.Context, opts WaitOptions, step func() (done bool, queryErr, fatal error)) error {
+	opts = opts.withDefaults()
+	delay := opts.PollInterval
+	retries := 0
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		done, queryErr, fatal := step()
+		if queryErr != nil {
+			if !isTransient(queryErr) || retries >= opts.MaxRetries {
+				return queryErr
+			}
+			retries++
+			delay = time.Duration(float64(delay) * opts.BackoffMultiplier)
+			t.Reset(opts.jitter(delay))
+			continue
+		}
+		retries = 0
+		delay = opts.PollInterval
+		if fatal != nil {
+			return fatal
+		}
+		if done {
+			return nil
+		}
+		t.Reset(opts.jitter(delay))
+	}
+}
+
+// isTransient reports whether err is an API error worth retrying.
+func isTransient(err error) bool {
+	return ParseGoogleAPIError(err).IsTransient()
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/api/run/v1"
+)
+
+// ServiceEvent is the outcome of one poll of a watched service: either its
+// latest state or the error querying it.
+type ServiceEvent struct {
+	Service *run.Service
+	Err     error
+}
+
+// Watch polls the service every interval and sends what it finds on the
+// returned channel, starting right away. Errors are sent as events too and
+// do not stop the watch. The channel is closed once ctx is done.
+func Watch(ctx context.Context, c *run.APIService, region, project, name string, interval time.Duration) (<-chan ServiceEvent, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("watch interval must be positive, got %v", interval)
+	}
+	ch := make(chan ServiceEvent)
+	go func() {
+		defer close(ch)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			svc, err := getService(c, region, project, name)
+			if err != nil {
+				err = fmt.Errorf("failed to query service: %w", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- ServiceEvent{Service: svc, Err: err}:
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// ServiceStatusEvent reports that a service watched by
+// WatchMultipleServices reached a terminal state: its condition became
+// True (Ready), or it failed or could not be queried (Err).
+type ServiceStatusEvent struct {
+	ServiceName string
+	Ready       bool
+	Err         error
+}
+
+// WatchMultipleServices polls each of the named services every interval
+// until the condition is True or False, and sends one event per service
+// as each gets there. Transient errors querying a service are retried with
+// backoff as in DefaultWaitOptions, after which the service reports the
+// error. The channel is closed once every service has reported in, or once
+// ctx is done.
+func WatchMultipleServices(ctx context.Context, c *run.APIService, region, project string, names []string, condition string, interval time.Duration) <-chan ServiceStatusEvent {
+	opts := DefaultWaitOptions()
+	if interval > 0 {
+		opts.PollInterval = interval
+	}
+	ch := make(chan ServiceStatusEvent)
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			pred := conditionTrue(condition)
+			ev := ServiceStatusEvent{ServiceName: name}
+			ev.Err = pollWithBackoff(ctx, opts, func() (bool, error, error) {
+				svc, err := getService(c, region, project, name)
+				if err != nil {
+					return false, fmt.Errorf("failed to query service: %w", err), nil
+				}
+				done, err := pred(svc)
+				return done, nil, err
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			ev.Ready = ev.Err == nil
+			select {
+			case <-ctx.Done():
+			case ch <- ev:
+			}
+		}(name)
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/api/run/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// serverAnnotations are set by Cloud Run on every write and are not part of
+// the desired state of a service.
+var serverAnnotations = []string{
+	"serving.knative.dev/creator",
+	"serving.knative.dev/lastModifier",
+	"run.googleapis.com/operation-id",
+}
+
+// ExportServiceYAML returns the YAML representation of svc without the
+// fields managed by the server, so that it can be fed back to
+// ImportServiceYAML and deployed.
+func ExportServiceYAML(svc *run.Service) (string, error) {
+	out, err := copyService(svc)
+	if err != nil {
+		return "", err
+	}
+	stripServerFields(out)
+	b, err := yaml.Marshal(out)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal service to yaml: %w", err)
+	}
+	return string(b), nil
+}
+
+// ImportServiceYAML parses a Knative Service definition, such as the one
+// shown in the YAML tab of the Cloud Console or returned by
+// ExportServiceYAML. Missing or wrong required fields are reported together
+// in a *ValidationError.
+func ImportServiceYAML(data []byte) (*run.Service, error) {
+	var svc run.Service
+	if err := yaml.Unmarshal(data, &svc); err != nil {
+		return nil, fmt.Errorf("failed to parse service yaml: %w", err)
+	}
+	var problems []string
+	if svc.ApiVersion != "serving.knative.dev/v1" {
+		problems = append(problems, fmt.Sprintf("apiVersion must be serving.knative.dev/v1, got %q", svc.ApiVersion))
+	}
+	if svc.Kind != "Service" {
+		problems = append(problems, fmt.Sprintf("kind must be Service, got %q", svc.Kind))
+	}
+	if svc.Metadata == nil || svc.Metadata.Name == "" {
+		problems = append(problems, "metadata.name is required")
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return &svc, nil
+}
+
+// copyService returns a deep copy of svc.
+func copyService(svc *run.Service) (*run.Service, error) {
+	b, err := json.Marshal(svc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy service: %w", err)
+	}
+	var out run.Service
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("failed to copy service: %w", err)
+	}
+	return &out, nil
+}
+
+// stripServerFields clears the status and the metadata fields of svc that
+// are populated by the server.
+func stripServerFields(svc *run.Service) {
+	svc.Status = nil
+	m := svc.Metadata
+	if m == nil {
+		return
+	}
+	m.ResourceVersion = ""
+	m.Uid = ""
+	m.CreationTimestamp = ""
+	m.SelfLink = ""
+	m.Generation = 0
+	for _, k := range serverAnnotations {
+		delete(m.Annotations, k)
+	}
+	if len(m.Annotations) == 0 {
+		m.Annotations = nil
+	}
+}